@@ -0,0 +1,25 @@
+package xlog
+
+import (
+	"os"
+	"runtime"
+)
+
+// LogStartupBanner logs an Info line identifying the process: the Go
+// version, GOOS/GOARCH, the PID and Options.AppVersion when set. It is
+// meant as the first line of a program.
+func (l *Logger) LogStartupBanner() {
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	kv := []interface{}{
+		"go", runtime.Version(),
+		"os", runtime.GOOS,
+		"arch", runtime.GOARCH,
+		"pid", os.Getpid(),
+	}
+	if l.appVersion != "" {
+		kv = append(kv, "version", l.appVersion)
+	}
+	l.outputw(LevelInfo, "starting", kv)
+}
@@ -0,0 +1,32 @@
+package xlog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLogStartupBanner(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{AppVersion: "v1.2.3"})
+	logger.LogStartupBanner()
+	logger.Close()
+
+	want := fmt.Sprintf("starting go=%s os=%s arch=%s pid=%d version=v1.2.3\n",
+		runtime.Version(), runtime.GOOS, runtime.GOARCH, os.Getpid())
+	if got := buf.String(); !strings.HasSuffix(got, want) || !strings.Contains(got, "banner_test.go") {
+		t.Errorf("got %q, want suffix %q", got, want)
+	}
+
+	buf.Reset()
+	logger = NewLogger(&buf, Options{Level: LevelWarn})
+	logger.LogStartupBanner()
+	logger.Close()
+	if buf.Len() != 0 {
+		t.Errorf("got %q with Info filtered", buf.String())
+	}
+	NewNopLogger().LogStartupBanner()
+}
@@ -0,0 +1,99 @@
+package xlog
+
+import (
+	"context"
+	"sort"
+)
+
+// contextKey is an entry of Options.ContextKeys.
+type contextKey struct {
+	name string
+	key  interface{}
+}
+
+func newContextKeys(keys map[string]interface{}) []contextKey {
+	cks := make([]contextKey, 0, len(keys))
+	for name, key := range keys {
+		cks = append(cks, contextKey{name: name, key: key})
+	}
+	sort.Slice(cks, func(i, j int) bool { return cks[i].name < cks[j].name })
+	return cks
+}
+
+// outputCtx is like output with the fields ctx holds for Options.ContextKeys.
+func (l *Logger) outputCtx(level LogLevel, ctx context.Context, format string, v ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	lc := l.newContent(level, format, v)
+	if fields := l.ctxFields(ctx); len(fields) > 0 {
+		// lc.Fields may be shared with the logger
+		n := len(lc.Fields)
+		lc.Fields = append(lc.Fields[:n:n], fields...)
+	}
+	l.enqueue(lc)
+}
+
+// ctxFields returns the fields of the values ctx holds for
+// Options.ContextKeys.
+func (l *Logger) ctxFields(ctx context.Context) []Field {
+	var fields []Field
+	for _, ck := range l.contextKeys {
+		if val := ctx.Value(ck.key); val != nil {
+			fields = append(fields, Field{Key: ck.name, Value: val})
+		}
+	}
+	return fields
+}
+
+// DebugCtx and its peers log like Debug, Info, ... with the values ctx
+// holds for Options.ContextKeys as fields, e.g. a request ID set by a
+// middleware.
+func (l *Logger) DebugCtx(ctx context.Context, v ...interface{}) {
+	l.outputCtx(LevelDebug, ctx, "", v...)
+}
+
+func (l *Logger) DebugCtxf(ctx context.Context, format string, v ...interface{}) {
+	l.outputCtx(LevelDebug, ctx, format, v...)
+}
+
+func (l *Logger) InfoCtx(ctx context.Context, v ...interface{}) {
+	l.outputCtx(LevelInfo, ctx, "", v...)
+}
+
+func (l *Logger) InfoCtxf(ctx context.Context, format string, v ...interface{}) {
+	l.outputCtx(LevelInfo, ctx, format, v...)
+}
+
+func (l *Logger) WarnCtx(ctx context.Context, v ...interface{}) {
+	l.outputCtx(LevelWarn, ctx, "", v...)
+}
+
+func (l *Logger) WarnCtxf(ctx context.Context, format string, v ...interface{}) {
+	l.outputCtx(LevelWarn, ctx, format, v...)
+}
+
+func (l *Logger) ErrorCtx(ctx context.Context, v ...interface{}) {
+	l.outputCtx(LevelError, ctx, "", v...)
+}
+
+func (l *Logger) ErrorCtxf(ctx context.Context, format string, v ...interface{}) {
+	l.outputCtx(LevelError, ctx, format, v...)
+}
+
+func (l *Logger) FatalCtx(ctx context.Context, v ...interface{}) {
+	l.outputCtx(LevelFatal, ctx, "", v...)
+}
+
+func (l *Logger) FatalCtxf(ctx context.Context, format string, v ...interface{}) {
+	l.outputCtx(LevelFatal, ctx, format, v...)
+}
+
+func (l *Logger) PanicCtx(ctx context.Context, v ...interface{}) {
+	l.outputCtx(LevelPanic, ctx, "", v...)
+}
+
+func (l *Logger) PanicCtxf(ctx context.Context, format string, v ...interface{}) {
+	l.outputCtx(LevelPanic, ctx, format, v...)
+}
@@ -0,0 +1,43 @@
+package xlog
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+type requestIDKey struct{}
+
+type tenantKey struct{}
+
+func TestCtx(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{
+		ContextKeys: map[string]interface{}{
+			"request_id": requestIDKey{},
+			"tenant":     tenantKey{},
+		},
+	})
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "r-42")
+	logger.WithFields(map[string]interface{}{"svc": "api"}).InfoCtxf(ctx, "took %dms", 12)
+	ctx = context.WithValue(ctx, tenantKey{}, "acme")
+	logger.ErrorCtx(ctx, "failed")
+	logger.WarnCtx(context.Background(), "no values")
+	logger.Close()
+
+	want := []string{
+		" took 12ms svc=api request_id=r-42",
+		" failed request_id=r-42 tenant=acme",
+		" no values",
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %q", lines)
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], want[i]) || !strings.Contains(lines[i], "ctx_test.go") {
+			t.Errorf("got %q, want suffix %q and the caller", lines[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !xlog_release
+// +build !xlog_release
+
+package xlog
+
+func Debug(v ...interface{}) {
+	std().output(LevelDebug, "", v...)
+}
+
+func Debugf(format string, v ...interface{}) {
+	std().output(LevelDebug, format, v...)
+}
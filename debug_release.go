@@ -0,0 +1,10 @@
+//go:build xlog_release
+// +build xlog_release
+
+package xlog
+
+// Debug does nothing in release builds, see the package documentation.
+func Debug(v ...interface{}) {}
+
+// Debugf does nothing in release builds, see the package documentation.
+func Debugf(format string, v ...interface{}) {}
@@ -0,0 +1,27 @@
+//go:build !xlog_release
+// +build !xlog_release
+
+package xlog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDebug(t *testing.T) {
+	old := std()
+	defer SetDefault(old)
+
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Level: LevelDebug})
+	SetDefault(logger)
+	Debug("plain")
+	Debugf("formatted %d", 1)
+	logger.Close()
+
+	got := buf.String()
+	if !strings.Contains(got, "[debug] debug_test.go:") || !strings.Contains(got, " plain\n") || !strings.Contains(got, " formatted 1\n") {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,34 @@
+package xlog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dumpPrefix marks the continuation lines of a dump.
+const dumpPrefix = "\n  | "
+
+// Dump logs label followed by v pretty-printed by Options.Dumper, %+v by
+// default, over as many lines as the dump has. The continuation lines
+// start with "  | " so parsers can attach them to the line of label, and
+// the dump is written as a whole, never interleaved with other lines.
+// Nothing is printed when level is disabled.
+func (l *Logger) Dump(level LogLevel, label string, v interface{}) {
+	l.outputDump(level, label, v)
+}
+
+func (l *Logger) outputDump(level LogLevel, label string, v interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	var dump string
+	if l.dumper != nil {
+		dump = l.dumper(v)
+	} else {
+		dump = fmt.Sprintf("%+v", v)
+	}
+	lc := l.newContent(level, "", nil)
+	lc.setMessage(label + dumpPrefix + strings.Replace(strings.TrimSuffix(dump, "\n"), "\n", dumpPrefix, -1))
+	l.enqueue(lc)
+}
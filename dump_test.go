@@ -0,0 +1,43 @@
+package xlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	var buf bytes.Buffer
+	dumped := 0
+	logger := NewLogger(&buf, Options{
+		Level: LevelInfo,
+		Dumper: func(v interface{}) string {
+			dumped++
+			b, _ := json.MarshalIndent(v, "", "  ")
+			return string(b)
+		},
+	})
+	logger.Dump(LevelDebug, "filtered", 1)
+	logger.Dump(LevelInfo, "state", map[string]int{"a": 1, "b": 2})
+	logger.Close()
+
+	if dumped != 1 {
+		t.Errorf("dumper called %d times, want 1", dumped)
+	}
+	want := " state\n  | {\n  |   \"a\": 1,\n  |   \"b\": 2\n  | }\n"
+	if got := buf.String(); !strings.HasSuffix(got, want) || strings.Count(got, "[info]") != 1 {
+		t.Errorf("got %q, want suffix %q", got, want)
+	}
+}
+
+func TestDumpDefault(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{})
+	logger.Dump(LevelInfo, "point", struct{ X, Y int }{1, 2})
+	logger.Close()
+
+	if got := buf.String(); !strings.HasSuffix(got, " point\n  | {X:1 Y:2}\n") {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,79 @@
+package xlog
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OptionsFromEnv returns Options configured by environment variables, unset
+// ones keeping their default:
+//
+//	LOG_LEVEL   debug, info, warn, error, panic or fatal, see ParseLevel
+//	LOG_FORMAT  text, logfmt or json
+//	LOG_ROTATE  daily, hourly, weekly or a duration like 30m, see RotateEvery
+//	LOG_UTC     a boolean, see Options.UTC
+//
+// It returns an error naming the variable for a malformed value. Where to
+// log is up to the caller, see NewLoggerFromEnv for LOG_FILE.
+func OptionsFromEnv() (Options, error) {
+	var opts Options
+	if s := os.Getenv("LOG_LEVEL"); s != "" {
+		level, err := ParseLevel(s)
+		if err != nil {
+			return Options{}, fmt.Errorf("LOG_LEVEL: %v", err)
+		}
+		opts.Level = level
+	}
+
+	if s := os.Getenv("LOG_FORMAT"); s != "" {
+		f, err := encodingFormatter(s)
+		if err != nil {
+			return Options{}, fmt.Errorf("LOG_FORMAT: %v", err)
+		}
+		opts.Formatter = f
+	}
+
+	switch s := strings.ToLower(os.Getenv("LOG_ROTATE")); s {
+	case "", "daily":
+	case "hourly":
+		opts.RotateInterval = Hourly
+	case "weekly":
+		opts.RotateInterval = Weekly
+	default:
+		d, err := time.ParseDuration(s)
+		if err != nil || d < time.Second {
+			return Options{}, fmt.Errorf("LOG_ROTATE: unknown interval %q, want daily, hourly, weekly or a duration of a second or more", s)
+		}
+		opts.RotateInterval = RotateEvery(d)
+	}
+
+	if s := os.Getenv("LOG_UTC"); s != "" {
+		utc, err := strconv.ParseBool(s)
+		if err != nil {
+			return Options{}, fmt.Errorf("LOG_UTC: %v", err)
+		}
+		opts.UTC = utc
+	}
+	return opts, nil
+}
+
+// NewLoggerFromEnv returns a logger configured by OptionsFromEnv, writing to
+// the file named by LOG_FILE as NewLoggerFromFile does, or to stdout when it
+// is unset.
+func NewLoggerFromEnv() (*Logger, error) {
+	opts, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if name := os.Getenv("LOG_FILE"); name != "" {
+		l, err := NewLoggerFromFile(name, opts)
+		if err != nil {
+			return nil, fmt.Errorf("LOG_FILE: %v", err)
+		}
+		return l, nil
+	}
+	return NewLogger(os.Stdout, opts), nil
+}
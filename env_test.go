@@ -0,0 +1,77 @@
+package xlog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setenv sets the environment variables of vars until the end of the test.
+func setenv(t *testing.T, vars map[string]string) {
+	for k, v := range vars {
+		k := k
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, v)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	setenv(t, map[string]string{
+		"LOG_LEVEL":  "WARN",
+		"LOG_FORMAT": "json",
+		"LOG_ROTATE": "hourly",
+		"LOG_UTC":    "true",
+	})
+	opts, err := OptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := opts.Formatter.(JSONFormatter); !ok || opts.Level != LevelWarn || opts.RotateInterval != Hourly || !opts.UTC {
+		t.Fatalf("got %+v", opts)
+	}
+}
+
+func TestOptionsFromEnvErrors(t *testing.T) {
+	for _, vars := range []map[string]string{
+		{"LOG_LEVEL": "loud"},
+		{"LOG_FORMAT": "xml"},
+		{"LOG_ROTATE": "fortnightly"},
+		{"LOG_ROTATE": "10ms"},
+		{"LOG_UTC": "maybe"},
+	} {
+		t.Run("", func(t *testing.T) {
+			setenv(t, vars)
+			if _, err := OptionsFromEnv(); err == nil {
+				t.Fatalf("%v: no error", vars)
+			}
+		})
+	}
+}
+
+func TestNewLoggerFromEnv(t *testing.T) {
+	logFile := filepath.Join(tempDir(t), "test.log")
+	setenv(t, map[string]string{"LOG_FILE": logFile, "LOG_LEVEL": "warn"})
+	logger, err := NewLoggerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("quiet")
+	logger.Warn("loud")
+	logger.Close()
+	if lines := readLines(t, logFile); len(lines) != 1 || !strings.HasSuffix(lines[0], " loud") {
+		t.Errorf("got %q", lines)
+	}
+
+	setenv(t, map[string]string{"LOG_FILE": filepath.Join(logFile, "not a dir", "x.log")})
+	if _, err := NewLoggerFromEnv(); err == nil || !strings.HasPrefix(err.Error(), "LOG_FILE: ") {
+		t.Errorf("got %v, want an error naming LOG_FILE", err)
+	}
+}
@@ -0,0 +1,125 @@
+//go:build windows
+// +build windows
+
+package xlog
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSourceW  = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEventW          = advapi32.NewProc("ReportEventW")
+)
+
+// event types of ReportEvent
+const (
+	eventError       = 1
+	eventWarning     = 2
+	eventInformation = 4
+)
+
+// eventType maps level to an event type: Error and above are errors, Warn
+// warnings and the rest information.
+func eventType(level LogLevel) byte {
+	switch {
+	case level >= LevelError:
+		return eventError
+	case level == LevelWarn:
+		return eventWarning
+	}
+	return eventInformation
+}
+
+// EventLogFormatter renders lines for a sink opened by OpenEventLog: the
+// event type of the level in a byte, followed by the line rendered by
+// Formatter, TextFormatter when nil.
+type EventLogFormatter struct {
+	Formatter Formatter
+}
+
+func (f EventLogFormatter) Format(lc LogContent) []byte {
+	formatter := f.Formatter
+	if formatter == nil {
+		formatter = TextFormatter{}
+	}
+	line := formatter.Format(lc)
+	event := make([]byte, 1+len(line))
+	event[0] = eventType(lc.Level)
+	copy(event[1:], line)
+	return event
+}
+
+// OpenEventLog opens the Windows Event Log for the event source source,
+// for a sink rendered by EventLogFormatter:
+//
+//	w, err := xlog.OpenEventLog("myservice")
+//	logger.AddSink(w, xlog.EventLogFormatter{}, xlog.LevelInfo)
+//
+// Each line is an event of ID 1. The source should be registered, e.g. by
+// the installer of the service with eventlog.InstallAsEventCreate of
+// golang.org/x/sys, or the Event Viewer shows the lines with a notice that
+// the description of the event is missing.
+func OpenEventLog(source string) (io.WriteCloser, error) {
+	name, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(name)))
+	if h == 0 {
+		return nil, err
+	}
+	return &eventLog{h: h}, nil
+}
+
+type eventLog struct {
+	mu sync.Mutex
+	h  uintptr
+}
+
+func (l *eventLog) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	typ, msg := p[0], p[1:]
+	if typ != eventError && typ != eventWarning && typ != eventInformation {
+		// not rendered by EventLogFormatter
+		typ, msg = eventInformation, p
+	}
+	s, err := syscall.UTF16PtrFromString(strings.Replace(strings.TrimRight(string(msg), "\r\n"), "\x00", " ", -1))
+	if err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.h == 0 {
+		return 0, ErrClosed
+	}
+	strs := [1]*uint16{s}
+	r, _, err := procReportEventW.Call(l.h, uintptr(typ), 0, 1, 0, 1, 0, uintptr(unsafe.Pointer(&strs[0])), 0)
+	if r == 0 {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (l *eventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.h == 0 {
+		return nil
+	}
+	r, _, err := procDeregisterEventSource.Call(l.h)
+	l.h = 0
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package xlog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEventLogFormatter(t *testing.T) {
+	for level, want := range map[LogLevel]byte{
+		LevelDebug: eventInformation,
+		LevelInfo:  eventInformation,
+		LevelWarn:  eventWarning,
+		LevelError: eventError,
+		LevelFatal: eventError,
+	} {
+		got := EventLogFormatter{}.Format(LogContent{Level: level, Args: []interface{}{"hello"}})
+		if got[0] != want || !strings.HasSuffix(string(got), " hello\n") {
+			t.Errorf("%v: got %q, want event type %d", level, got, want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package xlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+)
+
+const (
+	// fallbackAfter is the number of writes in a row failing on the file of
+	// NewLoggerFromFile before the logger falls back to stderr.
+	fallbackAfter = 3
+	// defaultReopenEvery is how often the file is reopened meanwhile.
+	defaultReopenEvery = 10 * time.Second
+)
+
+// watchFile follows the writes to the file of NewLoggerFromFile, lc being
+// the line just written with err: after fallbackAfter failures in a row,
+// e.g. on a file system gone read-only, it switches to stderr, then tries to
+// reopen the file every reopenEvery and switches back once it can. Each
+// switch is reported by a Warn line. c.mu must be held.
+func (c *core) watchFile(lc LogContent, err error) {
+	if c.f == nil {
+		return
+	}
+
+	if c.fallback {
+		if lc.Time.Before(c.nextReopen) {
+			return
+		}
+		c.nextReopen = lc.Time.Add(c.reopenEvery)
+		f, err := c.reopen()
+		if err != nil {
+			return
+		}
+		closeFile(c.f)
+		c.setOut(f)
+		c.f = f
+		c.fallback = false
+		c.writeFailures = 0
+		c.warnLocked("writing to %s again", f.Name())
+		return
+	}
+
+	if err == nil {
+		c.writeFailures = 0
+		return
+	}
+	c.writeFailures++
+	if c.writeFailures < fallbackAfter {
+		return
+	}
+	c.setOut(os.Stderr)
+	c.fallback = true
+	c.nextReopen = lc.Time.Add(c.reopenEvery)
+	c.warnLocked("writing to %s failed %d times: %v, logging to stderr", c.f.Name(), c.writeFailures, err)
+	// the line that failed last is not lost at least, the other outputs
+	// have it already
+	var dst io.Writer = c.out
+	if c.bw != nil {
+		dst = c.bw
+	}
+	dst.Write(c.formatter.Format(lc))
+}
+
+// checkFile reopens the file of NewLoggerFromFile once its name leads to
+// another file or to none, e.g. after logrotate moved or deleted it, which
+// the writes do not notice. It checks every reopenEvery, c.mu must be held.
+func (c *core) checkFile(now time.Time) {
+	if c.f == nil || c.fallback || now.Before(c.nextCheck) {
+		return
+	}
+	c.nextCheck = now.Add(c.reopenEvery)
+	if fi, err := os.Stat(c.f.Name()); err == nil {
+		cur, err := c.f.Stat()
+		if err != nil || os.SameFile(fi, cur) {
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		return
+	}
+	f, err := createFile(c.f.Name())
+	if err != nil {
+		// keeps writing to the file moved away rather than losing lines
+		return
+	}
+	c.setOut(f)
+	closeFile(c.f)
+	c.f = f
+	c.warnLocked("%s was moved or removed, reopened it", f.Name())
+}
+
+// reopen opens the current file again: the dated file of the current
+// period, or fileName with Options.RotateByRename.
+func (c *core) reopen() (*os.File, error) {
+	if c.byRename {
+		return createFile(c.fileName)
+	}
+	return openDated(c.fileName, c.rotateInterval, c.curTime)
+}
+
+// warnLocked writes a warning of the logger itself straight to the outputs,
+// unlike logf it can be called by the writer goroutine. c.mu must be held.
+func (c *core) warnLocked(format string, v ...interface{}) {
+	_, file, line, _ := runtime.Caller(1)
+	lc := LogContent{
+		Time:  c.now(),
+		Level: LevelWarn,
+		File:  file,
+		Line:  line,
+	}
+	lc.setMessage(fmt.Sprintf(format, v...))
+	c.decorate(&lc)
+	c.writeOut(lc)
+}
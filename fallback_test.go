@@ -0,0 +1,108 @@
+package xlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFallbackToStderr(t *testing.T) {
+	dir := tempDir(t)
+	stderrFile, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderrFile.Close()
+	stderr := os.Stderr
+	os.Stderr = stderrFile
+	defer func() { os.Stderr = stderr }()
+
+	clock := &testClock{t: time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)}
+	logFile := filepath.Join(dir, "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{RotateByRename: true, Now: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("first")
+	logger.Flush()
+
+	// the file is deleted and its descriptor becomes unusable
+	logger.mu.Lock()
+	logger.f.Close()
+	os.Remove(logFile)
+	logger.mu.Unlock()
+	for i := 0; i < fallbackAfter; i++ {
+		logger.Info("failed")
+	}
+	logger.Info("via stderr")
+	logger.Flush()
+
+	clock.Set(clock.Now().Add(defaultReopenEvery))
+	logger.Info("reopening")
+	logger.Info("back")
+	logger.Close()
+
+	b, err := ioutil.ReadFile(stderrFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	want := []string{"logging to stderr", " failed", " via stderr", " reopening"}
+	if len(got) != len(want) {
+		t.Fatalf("stderr got %q, want lines ending with %q", got, want)
+	}
+	for i := range want {
+		if !strings.HasSuffix(got[i], want[i]) {
+			t.Errorf("stderr line %d: got %q, want suffix %q", i, got[i], want[i])
+		}
+	}
+
+	lines := readLines(t, logFile)
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "writing to "+logFile+" again") || !strings.HasSuffix(lines[1], " back") {
+		t.Errorf("file got %q after recovery", lines)
+	}
+}
+
+func TestReopenMovedFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("open files can not be moved on Windows")
+	}
+	for _, move := range []string{"rename", "remove"} {
+		dir := tempDir(t)
+		clock := &testClock{t: time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)}
+		logFile := filepath.Join(dir, "test.log")
+		logger, err := NewLoggerFromFile(logFile, Options{RotateByRename: true, Now: clock.Now})
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Info("first")
+		logger.Flush()
+		if move == "rename" {
+			err = os.Rename(logFile, logFile+".1")
+		} else {
+			err = os.Remove(logFile)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Info("before the check")
+		logger.Flush()
+		clock.Set(clock.Now().Add(defaultReopenEvery))
+		logger.Info("second")
+		logger.Close()
+
+		lines := readLines(t, logFile)
+		if len(lines) != 2 || !strings.HasSuffix(lines[0], logFile+" was moved or removed, reopened it") || !strings.HasSuffix(lines[1], " second") {
+			t.Errorf("%s: got %q", move, lines)
+		}
+		if move == "rename" {
+			if lines := readLines(t, logFile+".1"); len(lines) != 2 || !strings.HasSuffix(lines[1], " before the check") {
+				t.Errorf("%s: moved file got %q", move, lines)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package xlog
+
+import "strconv"
+
+// maxFastArgs bounds the arguments sprintfFast handles, longer lists are
+// rare and go to fmt.
+const maxFastArgs = 16
+
+// sprintfFast formats like fmt.Sprintf, without its reflection, when format
+// has only plain %s, %d, %v and %% verbs and args only strings, integers
+// and, for %v, bools. It reports false for anything else, which the caller
+// formats with fmt: the output is the same as fmt's whenever it reports
+// true.
+func sprintfFast(format string, args []interface{}) (string, bool) {
+	if len(args) > maxFastArgs {
+		return "", false
+	}
+	var scratch [128]byte
+	buf := scratch[:0]
+	argNum := 0
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			buf = append(buf, c)
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", false
+		}
+		verb := format[i]
+		if verb == '%' {
+			buf = append(buf, '%')
+			continue
+		}
+		if argNum == len(args) {
+			return "", false
+		}
+		var ok bool
+		buf, ok = appendFast(buf, verb, args[argNum])
+		if !ok {
+			return "", false
+		}
+		argNum++
+	}
+	if argNum != len(args) {
+		return "", false
+	}
+	return string(buf), true
+}
+
+// appendFast appends arg formatted with verb, it reports false when fmt
+// would format it otherwise than plainly, e.g. %d of a string.
+func appendFast(buf []byte, verb byte, arg interface{}) ([]byte, bool) {
+	switch v := arg.(type) {
+	case string:
+		if verb == 's' || verb == 'v' {
+			return append(buf, v...), true
+		}
+	case bool:
+		if verb == 'v' {
+			return strconv.AppendBool(buf, v), true
+		}
+	case int:
+		return appendInt(buf, verb, int64(v))
+	case int8:
+		return appendInt(buf, verb, int64(v))
+	case int16:
+		return appendInt(buf, verb, int64(v))
+	case int32:
+		return appendInt(buf, verb, int64(v))
+	case int64:
+		return appendInt(buf, verb, v)
+	case uint:
+		return appendUint(buf, verb, uint64(v))
+	case uint8:
+		return appendUint(buf, verb, uint64(v))
+	case uint16:
+		return appendUint(buf, verb, uint64(v))
+	case uint32:
+		return appendUint(buf, verb, uint64(v))
+	case uint64:
+		return appendUint(buf, verb, v)
+	}
+	return buf, false
+}
+
+func appendInt(buf []byte, verb byte, v int64) ([]byte, bool) {
+	if verb != 'd' && verb != 'v' {
+		return buf, false
+	}
+	return strconv.AppendInt(buf, v, 10), true
+}
+
+func appendUint(buf []byte, verb byte, v uint64) ([]byte, bool) {
+	if verb != 'd' && verb != 'v' {
+		return buf, false
+	}
+	return strconv.AppendUint(buf, v, 10), true
+}
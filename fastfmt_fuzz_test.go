@@ -0,0 +1,26 @@
+//go:build go1.18
+// +build go1.18
+
+package xlog
+
+import (
+	"fmt"
+	"testing"
+)
+
+func FuzzSprintfFast(f *testing.F) {
+	f.Add("user %s has %d items, %v%%", "bob", int64(3), uint64(7), true)
+	f.Add("%v %s %d", "", int64(-1), uint64(0), false)
+	f.Fuzz(func(t *testing.T, format, s string, i int64, u uint64, b bool) {
+		for _, args := range [][]interface{}{
+			{s, i, u},
+			{i, s, b},
+			{b, u, int(i), int8(i), uint16(u)},
+		} {
+			got, ok := sprintfFast(format, args)
+			if want := fmt.Sprintf(format, args...); ok && got != want {
+				t.Errorf("sprintfFast(%q, %v) = %q, want %q", format, args, got, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package xlog
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSprintfFast(t *testing.T) {
+	tests := []struct {
+		format string
+		args   []interface{}
+		fast   bool
+	}{
+		{"plain", nil, true},
+		{"user %s logged in from %v", []interface{}{"bob", "10.0.0.1"}, true},
+		{"%d items, %v left, 100%%", []interface{}{3, int64(-7)}, true},
+		{"%v %v %d %d", []interface{}{true, uint8(255), uint64(1 << 63), int8(-128)}, true},
+		{"%d", []interface{}{"not a number"}, false},
+		{"%s", []interface{}{42}, false},
+		{"%x", []interface{}{42}, false},
+		{"%5d", []interface{}{42}, false},
+		{"%s", []interface{}{fmt.Errorf("err")}, false},
+		{"%s %s", []interface{}{"missing"}, false},
+		{"%s", []interface{}{"extra", 1}, false},
+		{"trailing %", nil, false},
+		{"%d", []interface{}{myInt(1)}, false},
+	}
+	for _, tt := range tests {
+		got, ok := sprintfFast(tt.format, tt.args)
+		if ok != tt.fast {
+			t.Errorf("sprintfFast(%q): fast %v, want %v", tt.format, ok, tt.fast)
+			continue
+		}
+		if want := fmt.Sprintf(tt.format, tt.args...); ok && got != want {
+			t.Errorf("sprintfFast(%q) = %q, want %q", tt.format, got, want)
+		}
+	}
+}
+
+// myInt could have a String method, it is left to fmt.
+type myInt int
+
+func BenchmarkSprintfFast(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sprintfFast("request %s done in %dms, status %v", []interface{}{"/api/users", 42, 200})
+	}
+}
+
+func BenchmarkSprintf(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = fmt.Sprintf("request %s done in %dms, status %v", "/api/users", 42, 200)
+	}
+}
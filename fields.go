@@ -0,0 +1,397 @@
+package xlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// badKey is the key of a value passed without a key to the w methods.
+const badKey = "!BADKEY"
+
+// Field is a key/value pair attached to a line. A Value of type
+// func() interface{} is only called when the line is written, for values
+// which are expensive to compute:
+//
+//	logger.Debugw("state", "dump", func() interface{} { return dump(state) })
+//
+// The typed constructors like String and Int build fields without boxing
+// their value, for the Fields methods on hot paths. They keep it in
+// unexported fields, so literals must name Key and Value.
+type Field struct {
+	Key   string
+	Value interface{}
+
+	// kind is the type of a field built by a typed constructor, which holds
+	// its value in num or str rather than in Value.
+	kind fieldKind
+	num  int64
+	str  string
+}
+
+type fieldKind uint8
+
+const (
+	anyField fieldKind = iota
+	stringField
+	int64Field
+	float64Field // num holds the bits
+	boolField    // num is 0 or 1
+	durationField
+)
+
+// String returns a field with a string value.
+func String(key, value string) Field {
+	return Field{Key: key, kind: stringField, str: value}
+}
+
+// Int returns a field with an int value.
+func Int(key string, value int) Field {
+	return Field{Key: key, kind: int64Field, num: int64(value)}
+}
+
+// Int64 returns a field with an int64 value.
+func Int64(key string, value int64) Field {
+	return Field{Key: key, kind: int64Field, num: value}
+}
+
+// Float64 returns a field with a float64 value.
+func Float64(key string, value float64) Field {
+	return Field{Key: key, kind: float64Field, num: int64(math.Float64bits(value))}
+}
+
+// Bool returns a field with a bool value.
+func Bool(key string, value bool) Field {
+	f := Field{Key: key, kind: boolField}
+	if value {
+		f.num = 1
+	}
+	return f
+}
+
+// Duration returns a field with a time.Duration value, written like
+// time.Duration.String in text and as nanoseconds in JSON.
+func Duration(key string, value time.Duration) Field {
+	return Field{Key: key, kind: durationField, num: int64(value)}
+}
+
+// Any returns a field with any value, like Field{Key: key, Value: value}.
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Interface returns the value of f whatever built it, e.g. an int64 for
+// Int, calling it if it is lazy. It is meant for formatters outside the
+// package.
+func (f Field) Interface() interface{} {
+	return f.value()
+}
+
+// value returns the value of f, calling it if it is lazy.
+func (f Field) value() interface{} {
+	switch f.kind {
+	case stringField:
+		return f.str
+	case int64Field:
+		return f.num
+	case float64Field:
+		return math.Float64frombits(uint64(f.num))
+	case boolField:
+		return f.num == 1
+	case durationField:
+		return time.Duration(f.num)
+	}
+	if lazy, ok := f.Value.(func() interface{}); ok {
+		return lazy()
+	}
+	return f.Value
+}
+
+// appendText appends the value of a typed field as writeFields would
+// format it, it reports false for the other fields.
+func (f Field) appendText(buf *bytes.Buffer) bool {
+	var scratch [32]byte
+	switch f.kind {
+	case stringField:
+		writeLogfmtValue(buf, f.str)
+	case int64Field:
+		buf.Write(strconv.AppendInt(scratch[:0], f.num, 10))
+	case float64Field:
+		buf.Write(strconv.AppendFloat(scratch[:0], math.Float64frombits(uint64(f.num)), 'g', -1, 64))
+	case boolField:
+		buf.Write(strconv.AppendBool(scratch[:0], f.num == 1))
+	case durationField:
+		buf.WriteString(time.Duration(f.num).String())
+	default:
+		return false
+	}
+	return true
+}
+
+// appendJSON is appendText for JSONFormatter, floats are left to
+// writeJSONValue which knows how JSON spells them.
+func (f Field) appendJSON(buf *bytes.Buffer) bool {
+	var scratch [32]byte
+	switch f.kind {
+	case stringField:
+		writeJSONString(buf, f.str)
+	case int64Field, durationField:
+		buf.Write(strconv.AppendInt(scratch[:0], f.num, 10))
+	case boolField:
+		buf.Write(strconv.AppendBool(scratch[:0], f.num == 1))
+	default:
+		return false
+	}
+	return true
+}
+
+// sweetenFields turns alternating keys and values into fields. Keys which
+// are not strings are formatted with fmt.Sprint, a trailing value without
+// a key is kept under the key "!BADKEY" rather than dropped.
+func sweetenFields(keysAndValues []interface{}) []Field {
+	if len(keysAndValues) == 0 {
+		return nil
+	}
+
+	fields := make([]Field, 0, (len(keysAndValues)+1)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i == len(keysAndValues)-1 {
+			fields = append(fields, Field{Key: badKey, Value: keysAndValues[i]})
+			break
+		}
+
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			key = fmt.Sprint(keysAndValues[i])
+		}
+		fields = append(fields, Field{Key: key, Value: keysAndValues[i+1]})
+	}
+	return fields
+}
+
+// writeFields appends fields as " k1=v1 k2=v2", quoting like logfmt. An
+// error which wraps others is followed by a cause for each, and by the
+// first frames of its stack trace if it has one:
+//
+//	err="read config: EOF" cause=EOF stack="main.go:12 main.go:30"
+func writeFields(buf *bytes.Buffer, fields []Field) {
+	for _, f := range fields {
+		buf.WriteByte(' ')
+		writeLogfmtValue(buf, f.Key)
+		buf.WriteByte('=')
+		if f.appendText(buf) {
+			continue
+		}
+		v := f.value()
+		err, ok := v.(error)
+		if !ok {
+			writeLogfmtValue(buf, fmt.Sprint(v))
+			continue
+		}
+
+		chain := errorChain(err)
+		writeLogfmtValue(buf, chain[0])
+		for _, cause := range chain[1:] {
+			buf.WriteString(" cause=")
+			writeLogfmtValue(buf, cause)
+		}
+		if stack := errorStack(err); len(stack) > 0 {
+			buf.WriteString(" stack=")
+			writeLogfmtValue(buf, strings.Join(stack, " "))
+		}
+	}
+}
+
+const (
+	// maxErrorChain bounds errorChain, e.g. against errors wrapping
+	// themselves.
+	maxErrorChain = 10
+	// maxStackFrames bounds errorStack.
+	maxStackFrames = 5
+)
+
+// errorChain returns the messages of err and of the errors it wraps, as
+// unwrapped by errors.Unwrap.
+func errorChain(err error) []string {
+	var chain []string
+	for ; err != nil && len(chain) < maxErrorChain; err = errors.Unwrap(err) {
+		chain = append(chain, err.Error())
+	}
+	return chain
+}
+
+// errorStack returns the first frames of the stack trace of err, e.g.
+// "main.go:12", if err prints one with %+v like the errors of
+// github.com/pkg/errors do: each function followed by its tab-indented
+// file:line. Their StackTrace method returns a type of that package, which
+// no interface can name here without importing it.
+func errorStack(err error) []string {
+	if _, ok := err.(fmt.Formatter); !ok {
+		return nil
+	}
+	var stack []string
+	for _, line := range strings.Split(fmt.Sprintf("%+v", err), "\n") {
+		if len(stack) == maxStackFrames {
+			break
+		}
+		if strings.HasPrefix(line, "\t") && strings.Contains(line, ".go:") {
+			stack = append(stack, path.Base(strings.TrimSpace(line)))
+		}
+	}
+	return stack
+}
+
+// WithFields returns a logger sharing the output of l whose lines carry
+// fields, after those of l, replacing the ones with the same keys:
+//
+//	logger.WithFields(map[string]interface{}{"user": id}).Info("login")
+func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
+	nl := l.clone()
+	nl.fields = make([]Field, 0, len(l.fields)+len(fields))
+	for _, f := range l.fields {
+		if _, ok := fields[f.Key]; !ok {
+			nl.fields = append(nl.fields, f)
+		}
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		nl.fields = append(nl.fields, Field{Key: k, Value: fields[k]})
+	}
+	return nl
+}
+
+// contextFields returns the fields of SetGoroutineContext for the calling
+// goroutine followed by those of WithFields, the first fields of a line.
+// The result must not be appended to in place.
+func (l *Logger) contextFields() []Field {
+	g := l.goroutines.lookup()
+	if len(g) == 0 {
+		return l.fields
+	}
+	if len(l.fields) == 0 {
+		return g
+	}
+	return append(g[:len(g):len(g)], l.fields...)
+}
+
+// outputw logs msg with fields built from keysAndValues.
+func (l *Logger) outputw(level LogLevel, msg string, keysAndValues []interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	lc := l.newContent(level, "", nil)
+	lc.setMessage(msg)
+	lc.Fields = append(lc.Fields[:len(lc.Fields):len(lc.Fields)], sweetenFields(keysAndValues)...)
+	l.enqueue(lc)
+}
+
+// outputFields logs msg with fields, copied to a pooled slice so that the
+// caller's can stay on its stack.
+func (l *Logger) outputFields(level LogLevel, msg string, fields []Field) {
+	if !l.enabled(level) {
+		return
+	}
+
+	lc := l.newContent(level, "", nil)
+	lc.setMessage(msg)
+	lc.fields = getFields(lc.Fields, fields)
+	lc.Fields = *lc.fields
+	l.enqueue(lc)
+}
+
+// fieldsPool recycles the fields copied by outputFields.
+var fieldsPool = sync.Pool{
+	New: func() interface{} {
+		s := make([]Field, 0, 8)
+		return &s
+	},
+}
+
+func getFields(context, fields []Field) *[]Field {
+	s := fieldsPool.Get().(*[]Field)
+	*s = append(append((*s)[:0], context...), fields...)
+	return s
+}
+
+func putFields(fields *[]Field) {
+	if fields == nil || cap(*fields) > maxPooledArgs {
+		return
+	}
+	s := *fields
+	for i := range s {
+		s[i] = Field{}
+	}
+	*fields = s[:0]
+	fieldsPool.Put(fields)
+}
+
+// DebugFields logs msg at debug level with fields built by the typed
+// constructors, e.g.
+//
+//	logger.DebugFields("request done", xlog.Int("status", 200), xlog.Duration("took", d))
+//
+// Unlike Debugw it does not allocate for the fields.
+func (l *Logger) DebugFields(msg string, fields ...Field) {
+	l.outputFields(LevelDebug, msg, fields)
+}
+
+func (l *Logger) InfoFields(msg string, fields ...Field) {
+	l.outputFields(LevelInfo, msg, fields)
+}
+
+func (l *Logger) WarnFields(msg string, fields ...Field) {
+	l.outputFields(LevelWarn, msg, fields)
+}
+
+func (l *Logger) ErrorFields(msg string, fields ...Field) {
+	l.outputFields(LevelError, msg, fields)
+}
+
+func (l *Logger) FatalFields(msg string, fields ...Field) {
+	l.outputFields(LevelFatal, msg, fields)
+}
+
+func (l *Logger) PanicFields(msg string, fields ...Field) {
+	l.outputFields(LevelPanic, msg, fields)
+}
+
+// Debugw logs msg at debug level with alternating keys and values, e.g.
+//
+//	logger.Debugw("request done", "status", 200, "path", "/")
+//
+// It avoids building a map for the fields.
+func (l *Logger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.outputw(LevelDebug, msg, keysAndValues)
+}
+
+func (l *Logger) Infow(msg string, keysAndValues ...interface{}) {
+	l.outputw(LevelInfo, msg, keysAndValues)
+}
+
+func (l *Logger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.outputw(LevelWarn, msg, keysAndValues)
+}
+
+func (l *Logger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.outputw(LevelError, msg, keysAndValues)
+}
+
+func (l *Logger) Fatalw(msg string, keysAndValues ...interface{}) {
+	l.outputw(LevelFatal, msg, keysAndValues)
+}
+
+func (l *Logger) Panicw(msg string, keysAndValues ...interface{}) {
+	l.outputw(LevelPanic, msg, keysAndValues)
+}
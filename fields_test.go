@@ -0,0 +1,218 @@
+package xlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSugaredFields(t *testing.T) {
+	tests := []struct {
+		keysAndValues []interface{}
+		want          string
+	}{
+		{nil, " done\n"},
+		{[]interface{}{"status", 200, "path", "/a b"}, ` done status=200 path="/a b"` + "\n"},
+		{[]interface{}{"status", 200, "dangling"}, ` done status=200 !BADKEY=dangling` + "\n"},
+		{[]interface{}{1, "one"}, ` done 1=one` + "\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logger := NewLogger(&buf, Options{})
+		logger.Infow("done", tt.keysAndValues...)
+		logger.Close()
+		if got := buf.String(); !strings.HasSuffix(got, tt.want) {
+			t.Errorf("Infow(%v) = %q, want suffix %q", tt.keysAndValues, got, tt.want)
+		}
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{})
+	req := logger.WithFields(map[string]interface{}{"user": 7, "path": "/a"})
+	req.WithFields(map[string]interface{}{"path": "/b"}).Infow("done", "status", 200)
+	req.Info("start")
+	logger.Info("plain")
+	logger.Close()
+
+	want := []string{
+		" done user=7 path=/b status=200",
+		" start path=/a user=7",
+		" plain",
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %q", lines)
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], want[i]) {
+			t.Errorf("got %q, want suffix %q", lines[i], want[i])
+		}
+	}
+}
+
+func TestTypedFields(t *testing.T) {
+	fields := []Field{
+		String("user", "bob smith"),
+		Int("status", 200),
+		Int64("size", -1),
+		Float64("ratio", 1e21),
+		Bool("cached", true),
+		Duration("took", 1500*time.Millisecond),
+	}
+	var text, js bytes.Buffer
+	logger := NewLogger(&text, Options{})
+	logger.AddSink(&js, JSONFormatter{}, LevelDebug)
+	logger.InfoFields("done", fields...)
+	logger.Infow("done", "user", "bob smith", "status", 200, "size", -1, "ratio", 1e21, "cached", true, "took", 1500*time.Millisecond)
+	logger.Close()
+
+	// typed fields are written like the same values given to Infow
+	for _, tt := range []struct {
+		out  string
+		want string
+	}{
+		{text.String(), ` done user="bob smith" status=200 size=-1 ratio=1e+21 cached=true took=1.5s`},
+		{js.String(), `"msg":"done","user":"bob smith","status":200,"size":-1,"ratio":1e+21,"cached":true,"took":1500000000}`},
+	} {
+		lines := strings.Split(tt.out, "\n")
+		for _, line := range lines[:2] {
+			if !strings.HasSuffix(line, tt.want) {
+				t.Errorf("got %q, want suffix %q", line, tt.want)
+			}
+		}
+	}
+}
+
+func TestFieldInterface(t *testing.T) {
+	for _, tt := range []struct {
+		f    Field
+		want interface{}
+	}{
+		{String("k", "v"), "v"},
+		{Int("k", 1), int64(1)},
+		{Bool("k", true), true},
+		{Duration("k", time.Second), time.Second},
+		{Any("k", func() interface{} { return 2 }), 2},
+	} {
+		if got := tt.f.Interface(); got != tt.want {
+			t.Errorf("%s.Interface() = %#v, want %#v", tt.f.Key, got, tt.want)
+		}
+	}
+}
+
+func TestTypedFieldsAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items under the race detector")
+	}
+	// synchronous, so the formatting allocations are counted too
+	logger := NewLogger(ioutil.Discard, Options{Synchronous: true})
+	defer logger.Close()
+
+	plain := testing.AllocsPerRun(100, func() {
+		logger.Info("done")
+	})
+	allocs := testing.AllocsPerRun(100, func() {
+		logger.InfoFields("done", String("user", "bob"), Int("status", 200), Bool("cached", true))
+	})
+	if allocs > plain {
+		t.Errorf("InfoFields: %v allocs, want at most the %v of a line without fields", allocs, plain)
+	}
+}
+
+func BenchmarkInfoFields(b *testing.B) {
+	logger := NewLogger(ioutil.Discard, Options{})
+	defer logger.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		logger.InfoFields("done", String("user", "bob"), Int("status", 200), Duration("took", time.Second))
+	}
+}
+
+func BenchmarkInfow(b *testing.B) {
+	logger := NewLogger(ioutil.Discard, Options{})
+	defer logger.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		logger.Infow("done", "user", "bob", "status", 200, "took", time.Second)
+	}
+}
+
+func TestLazyField(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Level: LevelInfo})
+	calls := 0
+	dump := func() interface{} {
+		calls++
+		return "expensive"
+	}
+	logger.Debugw("filtered", "dump", dump)
+	logger.Infow("written", "dump", dump)
+	logger.Close()
+
+	if calls != 1 {
+		t.Errorf("value computed %d times, want once", calls)
+	}
+	if got := buf.String(); !strings.HasSuffix(got, " written dump=expensive\n") {
+		t.Errorf("got %q", got)
+	}
+}
+
+// stackError prints a stack trace with %+v like the errors of
+// github.com/pkg/errors.
+type stackError struct{ error }
+
+func (e stackError) Format(s fmt.State, verb rune) {
+	io.WriteString(s, e.Error())
+	if verb == 'v' && s.Flag('+') {
+		io.WriteString(s, "\nmain.load\n\t/src/app/a.go:1\nmain.main\n\t/src/app/b.go:2")
+	}
+}
+
+func TestErrorChain(t *testing.T) {
+	base := stackError{errors.New("EOF")}
+	err := fmt.Errorf("load: %w", fmt.Errorf("read config: %w", base))
+
+	var text, js bytes.Buffer
+	logger := NewLogger(&text, Options{})
+	logger.AddSink(&js, JSONFormatter{}, LevelDebug)
+	logger.Errorw("failed", "err", err, "plain", errors.New("plain"))
+	logger.Close()
+
+	want := ` failed err="load: read config: EOF" cause="read config: EOF" cause=EOF plain=plain` + "\n"
+	if got := text.String(); !strings.HasSuffix(got, want) {
+		t.Errorf("text = %q, want suffix %q", got, want)
+	}
+	want = `"err":["load: read config: EOF","read config: EOF","EOF"],"plain":"plain"}`
+	if got := js.String(); !strings.Contains(got, want) {
+		t.Errorf("json = %q, want %q", got, want)
+	}
+
+	text.Reset()
+	logger = NewLogger(&text, Options{})
+	logger.Errorw("failed", "err", base)
+	logger.Close()
+	if got := text.String(); !strings.HasSuffix(got, ` failed err=EOF stack="a.go:1 b.go:2"`+"\n") {
+		t.Errorf("stack: got %q", got)
+	}
+}
+
+// loopError wraps itself.
+type loopError struct{}
+
+func (e loopError) Error() string { return "loop" }
+func (e loopError) Unwrap() error { return e }
+
+func TestErrorChainBound(t *testing.T) {
+	if n := len(errorChain(loopError{})); n != maxErrorChain {
+		t.Fatalf("chain of %d errors, want %d", n, maxErrorChain)
+	}
+}
@@ -0,0 +1,508 @@
+package xlog
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FluentOptions configures a FluentSink.
+type FluentOptions struct {
+	// Tag routes the lines in Fluentd, "xlog" by default.
+	Tag string
+	// RequireAck has each line acknowledged by the server, which then
+	// holds it, before the next one is sent. A line sent but not
+	// acknowledged is not sent again, the server may hold it already, it
+	// is counted in Dropped.
+	RequireAck bool
+	// Timeout bounds connecting, a write and the wait for an ack, 5
+	// seconds by default.
+	Timeout time.Duration
+	// QueueSize is the number of lines waiting to be sent, 1024 by
+	// default, the oldest is dropped when it is full.
+	QueueSize int
+}
+
+// FluentSink sends lines to Fluentd or Fluent Bit in the forward protocol,
+// msgpack over TCP, as records with the keys "level", "msg", "file", "id"
+// when set, then the fields and static fields, those named like the former
+// under "fields.", e.g. "fields.level". It is both the formatter and the
+// writer of a sink:
+//
+//	s, err := xlog.DialFluent("localhost:24224", xlog.FluentOptions{Tag: "app.api"})
+//	logger.AddSink(s, s, xlog.LevelInfo)
+//	defer s.Close()
+//
+// Like NetWriter, writes only queue the lines, a goroutine sends them and
+// reconnects after a failure, waiting from 100ms up to 30s between
+// attempts. Close the logger first so that its last lines make it to s.
+type FluentSink struct {
+	addr string
+	opts FluentOptions
+
+	dropped uint64
+	// mu orders Write and Close, so no line is queued once run drained
+	// the queue.
+	mu     sync.RWMutex
+	closed bool
+	queue  chan []byte
+	quit   chan struct{}
+	done   chan struct{}
+
+	// conn and r belong to run once started.
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// DialFluent connects to the forward input of Fluentd at addr.
+func DialFluent(addr string, opts FluentOptions) (*FluentSink, error) {
+	if opts.Tag == "" {
+		opts.Tag = "xlog"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = netTimeout
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultNetQueue
+	}
+	s := &FluentSink{
+		addr:  addr,
+		opts:  opts,
+		queue: make(chan []byte, opts.QueueSize),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	go s.run()
+	return s, nil
+}
+
+// Format renders lc as a forward protocol message, [tag, time, record] or
+// [tag, time, record, {"chunk": id}] with RequireAck.
+func (s *FluentSink) Format(lc LogContent) []byte {
+	var b []byte
+	if s.opts.RequireAck {
+		b = append(b, 0x94)
+	} else {
+		b = append(b, 0x93)
+	}
+	b = appendMsgpackString(b, s.opts.Tag)
+	// EventTime, the extension type 0 of Fluentd
+	b = append(b, 0xd7, 0x00)
+	b = appendUint32(b, uint32(lc.Time.Unix()))
+	b = appendUint32(b, uint32(lc.Time.Nanosecond()))
+
+	n := 2 + len(lc.Fields)
+	if lc.File != "" {
+		n++
+	}
+	if lc.ID != "" {
+		n++
+	}
+	if lc.static != nil {
+		n += len(lc.static.keys)
+	}
+	b = appendMsgpackMapHeader(b, n)
+	b = appendMsgpackString(b, "level")
+	b = appendMsgpackString(b, lc.Level.String())
+	b = appendMsgpackString(b, "msg")
+	b = appendMsgpackString(b, lc.Message())
+	if lc.File != "" {
+		b = appendMsgpackString(b, "file")
+		b = appendMsgpackString(b, lc.File+":"+strconv.Itoa(lc.Line))
+	}
+	if lc.ID != "" {
+		b = appendMsgpackString(b, "id")
+		b = appendMsgpackString(b, lc.ID)
+	}
+	for _, f := range lc.Fields {
+		b = appendMsgpackString(b, fluentKey(f.Key))
+		b = appendMsgpackValue(b, f.value())
+	}
+	if lc.static != nil {
+		for i, k := range lc.static.keys {
+			b = appendMsgpackString(b, fluentKey(k))
+			b = appendMsgpackString(b, lc.static.values[i])
+		}
+	}
+
+	if s.opts.RequireAck {
+		id := fluentChunkID()
+		b = appendMsgpackMapHeader(b, 1)
+		b = appendMsgpackString(b, "chunk")
+		b = appendMsgpackString(b, base64.StdEncoding.EncodeToString(id[:]))
+	}
+	return b
+}
+
+// fluentKey renames the keys of fields clashing with those of the record.
+func fluentKey(key string) string {
+	switch key {
+	case "level", "msg", "file", "id":
+		return "fields." + key
+	}
+	return key
+}
+
+// Write queues a copy of a message rendered by Format, it fails only once
+// s is closed.
+func (s *FluentSink) Write(p []byte) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	pushLine(s.queue, p, &s.dropped)
+	return len(p), nil
+}
+
+// Dropped returns the number of lines dropped on a full queue, left unsent
+// at Close, or sent without an ack with RequireAck.
+func (s *FluentSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *FluentSink) run() {
+	defer close(s.done)
+
+	backoff := netMinBackoff
+	// wait waits out the backoff after a failure, it reports false once s
+	// is closed meanwhile.
+	wait := func() bool {
+		select {
+		case <-time.After(backoff):
+		case <-s.quit:
+			return false
+		}
+		if backoff *= 2; backoff > netMaxBackoff {
+			backoff = netMaxBackoff
+		}
+		return true
+	}
+
+	for {
+		var p []byte
+		select {
+		case p = <-s.queue:
+		case <-s.quit:
+			s.drain()
+			return
+		}
+		if s.opts.RequireAck {
+			if _, err := fluentChunk(p); err != nil {
+				// not rendered by Format, no connection takes it
+				atomic.AddUint64(&s.dropped, 1)
+				continue
+			}
+		}
+
+		for {
+			if s.conn == nil {
+				if err := s.connect(); err != nil {
+					if !wait() {
+						atomic.AddUint64(&s.dropped, 1)
+						s.drain()
+						return
+					}
+					continue
+				}
+			}
+			sent, err := s.send(p)
+			if err == nil {
+				backoff = netMinBackoff
+				break
+			}
+			s.disconnect()
+			if sent {
+				// the server may hold the line, sending it again could
+				// duplicate it
+				atomic.AddUint64(&s.dropped, 1)
+				break
+			}
+			if !wait() {
+				atomic.AddUint64(&s.dropped, 1)
+				s.drain()
+				return
+			}
+		}
+	}
+}
+
+// drain sends the queued lines if connected, once s is closed, giving up on
+// the first failure, and counts the ones which could not be sent.
+func (s *FluentSink) drain() {
+	for {
+		select {
+		case p := <-s.queue:
+			if s.conn != nil {
+				if _, err := s.send(p); err == nil {
+					continue
+				}
+				s.disconnect()
+			}
+			atomic.AddUint64(&s.dropped, 1)
+		default:
+			s.disconnect()
+			return
+		}
+	}
+}
+
+func (s *FluentSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, s.opts.Timeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.r = bufio.NewReader(conn)
+	return nil
+}
+
+func (s *FluentSink) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// send writes p and waits for its ack with RequireAck. It reports whether
+// p was written, so that the server may hold it even on an error.
+func (s *FluentSink) send(p []byte) (bool, error) {
+	chunk := ""
+	if s.opts.RequireAck {
+		var err error
+		if chunk, err = fluentChunk(p); err != nil {
+			return false, err
+		}
+	}
+	s.conn.SetDeadline(time.Now().Add(s.opts.Timeout))
+	if _, err := s.conn.Write(p); err != nil {
+		return false, err
+	}
+	if !s.opts.RequireAck {
+		return true, nil
+	}
+
+	ack, err := readFluentAck(s.r)
+	if err != nil {
+		return true, err
+	}
+	if ack != chunk {
+		return true, fmt.Errorf("xlog: fluentd acked %q, want %q", ack, chunk)
+	}
+	return true, nil
+}
+
+// Close sends the lines still queued if connected, giving up on the first
+// failure, and closes the connection.
+func (s *FluentSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	s.closed = true
+	s.mu.Unlock()
+	close(s.quit)
+	<-s.done
+	return nil
+}
+
+// chunkSeq tells apart the chunk ids of fluentChunkID without randomness.
+var chunkSeq uint64
+
+// fluentChunkID returns a random id for a chunk, or one made of the time and
+// a counter should crypto/rand fail.
+func fluentChunkID() [16]byte {
+	var id [16]byte
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		binary.BigEndian.PutUint64(id[:8], uint64(time.Now().UnixNano()))
+		binary.BigEndian.PutUint64(id[8:], atomic.AddUint64(&chunkSeq, 1))
+	}
+	return id
+}
+
+// fluentChunk returns the chunk id at the end of a message rendered by
+// Format: a fixstr of the 24 bytes of base64 of 16 bytes.
+func fluentChunk(p []byte) (string, error) {
+	if len(p) < 25 || p[len(p)-25] != 0xa0|24 {
+		return "", errors.New("xlog: line not rendered by FluentSink.Format")
+	}
+	return string(p[len(p)-24:]), nil
+}
+
+// readFluentAck reads the {"ack": chunk} response of the server.
+func readFluentAck(r *bufio.Reader) (string, error) {
+	n, err := readMsgpackMapHeader(r)
+	if err != nil {
+		return "", err
+	}
+	var ack string
+	for i := 0; i < n; i++ {
+		key, err := readMsgpackString(r)
+		if err != nil {
+			return "", err
+		}
+		value, err := readMsgpackString(r)
+		if err != nil {
+			return "", err
+		}
+		if key == "ack" {
+			ack = value
+		}
+	}
+	return ack, nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n < 1<<8:
+		b = append(b, 0xd9, byte(n))
+	case n < 1<<16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n < 1<<16:
+		return append(b, 0xde, byte(n>>8), byte(n))
+	}
+	return appendUint32(append(b, 0xdf), uint32(n))
+}
+
+func appendMsgpackInt(b []byte, v int64) []byte {
+	if v >= -32 && v < 128 {
+		return append(b, byte(v))
+	}
+	return appendUint64(append(b, 0xd3), uint64(v))
+}
+
+// appendMsgpackValue appends numbers, booleans, nil and strings as such,
+// errors as their message and other values formatted with fmt.Sprint.
+func appendMsgpackValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case string:
+		return appendMsgpackString(b, v)
+	case int:
+		return appendMsgpackInt(b, int64(v))
+	case int8:
+		return appendMsgpackInt(b, int64(v))
+	case int16:
+		return appendMsgpackInt(b, int64(v))
+	case int32:
+		return appendMsgpackInt(b, int64(v))
+	case int64:
+		return appendMsgpackInt(b, v)
+	case uint:
+		return appendUint64(append(b, 0xcf), uint64(v))
+	case uint8:
+		return appendMsgpackInt(b, int64(v))
+	case uint16:
+		return appendMsgpackInt(b, int64(v))
+	case uint32:
+		return appendMsgpackInt(b, int64(v))
+	case uint64:
+		return appendUint64(append(b, 0xcf), v)
+	case float32:
+		return appendUint64(append(b, 0xcb), math.Float64bits(float64(v)))
+	case float64:
+		return appendUint64(append(b, 0xcb), math.Float64bits(v))
+	case error:
+		return appendMsgpackString(b, v.Error())
+	}
+	return appendMsgpackString(b, fmt.Sprint(v))
+}
+
+func readMsgpackMapHeader(r *bufio.Reader) (int, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c&0xf0 == 0x80:
+		return int(c & 0x0f), nil
+	case c == 0xde:
+		var buf [2]byte
+		_, err := io.ReadFull(r, buf[:])
+		return int(binary.BigEndian.Uint16(buf[:])), err
+	case c == 0xdf:
+		var buf [4]byte
+		_, err := io.ReadFull(r, buf[:])
+		return int(binary.BigEndian.Uint32(buf[:])), err
+	}
+	return 0, fmt.Errorf("xlog: msgpack map expected, got 0x%02x", c)
+}
+
+func readMsgpackString(r *bufio.Reader) (string, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	var n int
+	switch {
+	case c&0xe0 == 0xa0:
+		n = int(c & 0x1f)
+	case c == 0xd9:
+		l, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		n = int(l)
+	case c == 0xda:
+		var buf [2]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return "", err
+		}
+		n = int(binary.BigEndian.Uint16(buf[:]))
+	case c == 0xdb:
+		var buf [4]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return "", err
+		}
+		n = int(binary.BigEndian.Uint32(buf[:]))
+	default:
+		return "", fmt.Errorf("xlog: msgpack string expected, got 0x%02x", c)
+	}
+	s := make([]byte, n)
+	_, err = io.ReadFull(r, s)
+	return string(s), err
+}
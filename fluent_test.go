@@ -0,0 +1,188 @@
+package xlog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFluentFormat(t *testing.T) {
+	s := &FluentSink{opts: FluentOptions{Tag: "app"}}
+	got := s.Format(LogContent{
+		Time:   time.Unix(1, 2),
+		Level:  LevelInfo,
+		File:   "x.go",
+		Line:   12,
+		Args:   []interface{}{"hi"},
+		Fields: []Field{Int("n", 300), Bool("ok", true), String("msg", "x")},
+	})
+	want := []byte{
+		0x93, 0xa3, 'a', 'p', 'p',
+		0xd7, 0x00, 0, 0, 0, 1, 0, 0, 0, 2,
+		0x86,
+		0xa5, 'l', 'e', 'v', 'e', 'l', 0xa4, 'i', 'n', 'f', 'o',
+		0xa3, 'm', 's', 'g', 0xa2, 'h', 'i',
+		0xa4, 'f', 'i', 'l', 'e', 0xa7, 'x', '.', 'g', 'o', ':', '1', '2',
+		0xa1, 'n', 0xd3, 0, 0, 0, 0, 0, 0, 0x01, 0x2c,
+		0xa2, 'o', 'k', 0xc3,
+		0xaa, 'f', 'i', 'e', 'l', 'd', 's', '.', 'm', 's', 'g', 0xa1, 'x',
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got  % x\nwant % x", got, want)
+	}
+}
+
+// fluentAck is how fluentServer acks a message.
+type fluentAck int
+
+const (
+	ackChunk fluentAck = iota
+	ackOther           // with the chunk of another message
+	ackNone
+)
+
+// fluentServer accepts forward connections on a local port and acks each
+// message it receives as told. The first 16 messages are received.
+func fluentServer(t *testing.T, ack fluentAck) (net.Listener, chan []byte) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	received := make(chan []byte, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					b := make([]byte, 4096)
+					n, err := conn.Read(b)
+					if err != nil {
+						return
+					}
+					select {
+					case received <- b[:n]:
+					default:
+					}
+					chunk := string(b[n-24 : n])
+					switch ack {
+					case ackNone:
+						continue
+					case ackOther:
+						chunk = "bogus"
+					}
+					conn.Write(appendMsgpackString(appendMsgpackString([]byte{0x81}, "ack"), chunk))
+				}
+			}()
+		}
+	}()
+	return ln, received
+}
+
+func TestFluentAck(t *testing.T) {
+	ln, received := fluentServer(t, ackChunk)
+	defer ln.Close()
+
+	s, err := DialFluent(ln.Addr().String(), FluentOptions{RequireAck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := NewLogger(ioutil.Discard, Options{})
+	logger.AddSink(s, s, LevelDebug)
+	logger.Info("hello")
+	logger.Close()
+	s.Close()
+
+	if d := s.Dropped(); d != 0 {
+		t.Fatalf("Dropped = %d", d)
+	}
+	msg := <-received
+	if msg[0] != 0x94 || !bytes.Contains(msg, []byte("\xa5hello")) {
+		t.Errorf("got % x", msg)
+	}
+
+	// the chunk ids are unique
+	a, b := s.Format(LogContent{}), s.Format(LogContent{})
+	if ca, _ := fluentChunk(a); ca == "" {
+		t.Error("no chunk")
+	} else if cb, _ := fluentChunk(b); ca == cb {
+		t.Error("same chunk twice")
+	}
+	if _, err := readFluentAck(bufio.NewReader(bytes.NewReader([]byte{0x81, 0xa1, 'x'}))); err == nil {
+		t.Error("no error on a truncated ack")
+	}
+}
+
+func TestFluentAckMismatch(t *testing.T) {
+	ln, received := fluentServer(t, ackOther)
+	defer ln.Close()
+
+	s, err := DialFluent(ln.Addr().String(), FluentOptions{RequireAck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := NewLogger(ioutil.Discard, Options{})
+	logger.AddSink(s, s, LevelDebug)
+	logger.Info("one")
+	logger.Close()
+	s.Close()
+
+	// the server may hold it, it is not sent again
+	time.Sleep(50 * time.Millisecond)
+	if n := len(received); n != 1 {
+		t.Errorf("the server received %d messages, want 1", n)
+	}
+	if d := s.Dropped(); d != 1 {
+		t.Errorf("Dropped = %d, want 1", d)
+	}
+}
+
+func TestFluentNoAck(t *testing.T) {
+	ln, _ := fluentServer(t, ackNone)
+	defer ln.Close()
+	s, err := DialFluent(ln.Addr().String(), FluentOptions{RequireAck: true, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := NewLogger(ioutil.Discard, Options{Synchronous: true})
+	logger.AddSink(s, s, LevelDebug)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		logger.Info("hello")
+	}
+	// the lines are queued, the logger does not wait for the server
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("100 lines took %v", d)
+	}
+	logger.Close()
+	s.Close()
+	if d := s.Dropped(); d != 100 {
+		t.Errorf("Dropped = %d, want 100", d)
+	}
+	if _, err := s.Write([]byte("late")); err != ErrClosed {
+		t.Errorf("Write after Close = %v, want ErrClosed", err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) { return 0, errors.New("no entropy") }
+
+func TestFluentChunkIDWithoutRandom(t *testing.T) {
+	reader := rand.Reader
+	rand.Reader = failingReader{}
+	defer func() { rand.Reader = reader }()
+
+	if a, b := fluentChunkID(), fluentChunkID(); a == b || a == [16]byte{} {
+		t.Errorf("got ids %x and %x", a, b)
+	}
+}
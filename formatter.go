@@ -0,0 +1,453 @@
+package xlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogContent is a single log line as it is handed to a Formatter.
+type LogContent struct {
+	Time   time.Time
+	Level  LogLevel
+	ID     string // correlation ID, empty when not set
+	File   string
+	Line   int
+	Format string
+	Args   []interface{}
+	Fields []Field
+
+	// buf is the scratch buffer lent by the logger for the duration of one
+	// Format call.
+	buf *bytes.Buffer
+	// msg is the message already rendered by the logger, e.g. truncated.
+	msg      string
+	rendered bool
+	// static are the Options.StaticFields of the logger.
+	static *staticFields
+	// flag is the Options.Flag of the logger.
+	flag int
+	// shortLevel is the Options.ShortLevel of the logger.
+	shortLevel bool
+	// separator is the Options.FieldSeparator of the logger.
+	separator string
+	// tees are the writers added by WithWriter to the logger of the line.
+	tees []*tee
+	// args is the pooled slice behind Args, recycled once the line is
+	// written.
+	args *[]interface{}
+	// fields is the pooled slice behind Fields, if any.
+	fields *[]Field
+	// written is closed once a Fatal or Panic line is written.
+	written chan struct{}
+	// exitCode is the status a Fatal line exits with.
+	exitCode int
+}
+
+// Message renders Format and Args like fmt.Sprintf, or like fmt.Sprint when
+// Format is empty.
+func (lc LogContent) Message() string {
+	if lc.rendered {
+		return lc.msg
+	}
+	if lc.Format == "" {
+		return fmt.Sprint(lc.Args...)
+	}
+	if msg, ok := sprintfFast(lc.Format, lc.Args); ok {
+		return msg
+	}
+	return fmt.Sprintf(lc.Format, lc.Args...)
+}
+
+func (lc *LogContent) setMessage(msg string) {
+	lc.msg = msg
+	lc.rendered = true
+}
+
+// buffer returns an empty buffer to render into, reusing the logger's pooled
+// one when available.
+func (lc LogContent) buffer() *bytes.Buffer {
+	if lc.buf == nil {
+		return new(bytes.Buffer)
+	}
+	lc.buf.Reset()
+	return lc.buf
+}
+
+// staticFields are the Options.StaticFields of a logger, sorted by key and
+// rendered once since they never change.
+type staticFields struct {
+	keys   []string
+	values []string
+	// text is " k1=v1 k2=v2", appended by the text and logfmt formatters.
+	text string
+	// json is `,"k1":"v1","k2":"v2"`, appended by the JSON formatter.
+	json string
+}
+
+func newStaticFields(fields map[string]string) *staticFields {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	sf := new(staticFields)
+	for k := range fields {
+		sf.keys = append(sf.keys, k)
+	}
+	sort.Strings(sf.keys)
+
+	var buf, jsonBuf bytes.Buffer
+	for _, k := range sf.keys {
+		sf.values = append(sf.values, fields[k])
+		buf.WriteByte(' ')
+		writeLogfmtValue(&buf, k)
+		buf.WriteByte('=')
+		writeLogfmtValue(&buf, fields[k])
+
+		jsonBuf.WriteByte(',')
+		writeJSONString(&jsonBuf, k)
+		jsonBuf.WriteByte(':')
+		writeJSONString(&jsonBuf, fields[k])
+	}
+	sf.text = buf.String()
+	sf.json = jsonBuf.String()
+	return sf
+}
+
+// StaticFields returns the Options.StaticFields of the logger of lc, sorted
+// by key. It is meant for formatters outside the package.
+func (lc LogContent) StaticFields() []Field {
+	if lc.static == nil {
+		return nil
+	}
+	fields := make([]Field, len(lc.static.keys))
+	for i, k := range lc.static.keys {
+		fields[i] = String(k, lc.static.values[i])
+	}
+	return fields
+}
+
+// staticText returns the rendered static fields of lc, if any.
+func (lc LogContent) staticText() string {
+	if lc.static == nil {
+		return ""
+	}
+	return lc.static.text
+}
+
+// Formatter renders a LogContent into the bytes written to the output,
+// including the trailing newline. The returned slice is only used until the
+// next call to Format, and lc must not be retained: its Args are recycled.
+type Formatter interface {
+	Format(lc LogContent) []byte
+}
+
+// Encoder is implemented by formatters which write lines straight to the
+// output rather than returning bytes, e.g. to stream a binary encoding. The
+// logger calls Encode with its output, extra writers added by AddWriter
+// still get the bytes returned by Format.
+type Encoder interface {
+	Formatter
+	Encode(lc LogContent, w io.Writer) error
+}
+
+// EncoderFunc adapts a function writing a line to w into an Encoder.
+type EncoderFunc func(lc LogContent, w io.Writer) error
+
+func (f EncoderFunc) Encode(lc LogContent, w io.Writer) error {
+	return f(lc, w)
+}
+
+// Format encodes lc into a buffer, for the outputs which need bytes.
+func (f EncoderFunc) Format(lc LogContent) []byte {
+	buf := lc.buffer()
+	f(lc, buf)
+	return buf.Bytes()
+}
+
+// TextFormatter renders lines as "time [level] file:line msg", or
+// "time [level] [id] file:line msg" when the line has a correlation ID.
+// Fields and then static fields follow the message as key=value pairs. The
+// file:line is left out when lines have no caller, see Options.Flag. The
+// time has microseconds when the logger's flag has log.Lmicroseconds, and
+// the level is a single letter like "I" with Options.ShortLevel. The
+// segments are separated by Options.FieldSeparator, a space by default.
+type TextFormatter struct{}
+
+func (TextFormatter) Format(lc LogContent) []byte {
+	buf := lc.buffer()
+	sep := lc.separator
+	if sep == "" {
+		sep = " "
+	}
+
+	year, month, day := lc.Time.Date()
+	hour, min, sec := lc.Time.Clock()
+
+	buf.WriteString(strconv.Itoa(year))
+	buf.WriteByte('/')
+	if month < 10 {
+		buf.WriteByte('0')
+	}
+	buf.WriteString(strconv.Itoa(int(month)))
+	buf.WriteByte('/')
+	if day < 10 {
+		buf.WriteByte('0')
+	}
+	buf.WriteString(strconv.Itoa(day))
+	buf.WriteByte(' ')
+	if hour < 10 {
+		buf.WriteByte('0')
+	}
+	buf.WriteString(strconv.Itoa(hour))
+	buf.WriteByte(':')
+	if min < 10 {
+		buf.WriteByte('0')
+	}
+	buf.WriteString(strconv.Itoa(min))
+	buf.WriteByte(':')
+	if sec < 10 {
+		buf.WriteByte('0')
+	}
+	buf.WriteString(strconv.Itoa(sec))
+	if lc.flag&log.Lmicroseconds != 0 {
+		buf.WriteByte('.')
+		us := lc.Time.Nanosecond() / 1000
+		for d := 100000; d > 1 && us < d; d /= 10 {
+			buf.WriteByte('0')
+		}
+		buf.WriteString(strconv.Itoa(us))
+	}
+	buf.WriteString(sep)
+	if lc.shortLevel {
+		buf.WriteByte(lc.Level.letter())
+	} else {
+		buf.WriteByte('[')
+		buf.WriteString(lc.Level.String())
+		buf.WriteByte(']')
+	}
+	buf.WriteString(sep)
+	if lc.ID != "" {
+		buf.WriteByte('[')
+		buf.WriteString(lc.ID)
+		buf.WriteByte(']')
+		buf.WriteString(sep)
+	}
+	if lc.File != "" {
+		buf.WriteString(lc.File)
+		buf.WriteByte(':')
+		buf.WriteString(strconv.Itoa(lc.Line))
+		buf.WriteString(sep)
+	}
+	buf.WriteString(lc.Message())
+	writeFields(buf, lc.Fields)
+	buf.WriteString(lc.staticText())
+	if b := buf.Bytes(); b[len(b)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+// encodingFormatter returns the formatter named by Options.Encoding,
+// TextFormatter with an error for an unknown name.
+func encodingFormatter(encoding string) (Formatter, error) {
+	switch strings.ToLower(encoding) {
+	case "", "text":
+		return TextFormatter{}, nil
+	case "logfmt":
+		return LogfmtFormatter{}, nil
+	case "json":
+		return JSONFormatter{TimeKey: "ts", CallerKey: "caller"}, nil
+	}
+	return TextFormatter{}, fmt.Errorf("xlog: unknown encoding %q, want text, logfmt or json", encoding)
+}
+
+// LogfmtFormatter renders lines as logfmt key=value pairs:
+//
+//	time=2006-01-02T15:04:05.000000+08:00 level=info file=x.go:12 msg="hello world"
+//
+// Values containing spaces, '=', quotes or control characters are quoted.
+// TimeKey and CallerKey rename the time and file keys, e.g. to "ts" and
+// "caller" as some collectors expect.
+type LogfmtFormatter struct {
+	TimeKey   string
+	CallerKey string
+}
+
+const logfmtTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+func (f LogfmtFormatter) Format(lc LogContent) []byte {
+	buf := lc.buffer()
+
+	if f.TimeKey != "" {
+		buf.WriteString(f.TimeKey)
+	} else {
+		buf.WriteString("time")
+	}
+	buf.WriteByte('=')
+	buf.WriteString(lc.Time.Format(logfmtTimeLayout))
+	buf.WriteString(" level=")
+	buf.WriteString(strings.TrimPrefix(lc.Level.String(), "level="))
+	if lc.ID != "" {
+		buf.WriteString(" id=")
+		writeLogfmtValue(buf, lc.ID)
+	}
+	if lc.File != "" {
+		buf.WriteByte(' ')
+		if f.CallerKey != "" {
+			buf.WriteString(f.CallerKey)
+		} else {
+			buf.WriteString("file")
+		}
+		buf.WriteByte('=')
+		writeLogfmtValue(buf, lc.File+":"+strconv.Itoa(lc.Line))
+	}
+	buf.WriteString(" msg=")
+	writeLogfmtValue(buf, lc.Message())
+	writeFields(buf, lc.Fields)
+	buf.WriteString(lc.staticText())
+	buf.WriteByte('\n')
+
+	return buf.Bytes()
+}
+
+func writeLogfmtValue(buf *bytes.Buffer, s string) {
+	if logfmtNeedsQuote(s) {
+		buf.WriteString(strconv.Quote(s))
+	} else {
+		buf.WriteString(s)
+	}
+}
+
+func logfmtNeedsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	return strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == 0x7f
+	}) >= 0
+}
+
+// JSONFormatter renders lines as JSON objects, one per line:
+//
+//	{"time":"2006-01-02T15:04:05.000000+08:00","level":"info","file":"x.go","line":12,"msg":"hello"}
+//
+// An "id" follows the level when the line has a correlation ID, fields and
+// static fields follow the message. An error field which wraps others is
+// an array of the messages of the chain, and its stack trace, if any,
+// follows under the key with a "_stack" suffix. The line is a number, so
+// callers can be queried without parsing the file name.
+//
+// TimeKey renames the time key, and CallerKey puts the file and line
+// together under that key instead, e.g. "ts" and "caller" as for
+// Options{Encoding: "json"}:
+//
+//	{"ts":"2006-01-02T15:04:05.000000+08:00","level":"info","caller":"x.go:12","msg":"hello"}
+type JSONFormatter struct {
+	TimeKey   string
+	CallerKey string
+}
+
+func (f JSONFormatter) Format(lc LogContent) []byte {
+	buf := lc.buffer()
+
+	buf.WriteByte('{')
+	if f.TimeKey != "" {
+		writeJSONString(buf, f.TimeKey)
+	} else {
+		buf.WriteString(`"time"`)
+	}
+	buf.WriteString(`:"`)
+	buf.WriteString(lc.Time.Format(logfmtTimeLayout))
+	buf.WriteString(`","level":`)
+	writeJSONString(buf, lc.Level.String())
+	if lc.ID != "" {
+		buf.WriteString(`,"id":`)
+		writeJSONString(buf, lc.ID)
+	}
+	if lc.File != "" && f.CallerKey != "" {
+		buf.WriteByte(',')
+		writeJSONString(buf, f.CallerKey)
+		buf.WriteByte(':')
+		writeJSONString(buf, lc.File+":"+strconv.Itoa(lc.Line))
+	} else if lc.File != "" {
+		buf.WriteString(`,"file":`)
+		writeJSONString(buf, lc.File)
+		buf.WriteString(`,"line":`)
+		buf.WriteString(strconv.Itoa(lc.Line))
+	}
+	buf.WriteString(`,"msg":`)
+	writeJSONString(buf, lc.Message())
+	for _, f := range lc.Fields {
+		buf.WriteByte(',')
+		writeJSONString(buf, f.Key)
+		buf.WriteByte(':')
+		if f.appendJSON(buf) {
+			continue
+		}
+		v := f.value()
+		writeJSONValue(buf, v)
+		if err, ok := v.(error); ok {
+			if stack := errorStack(err); len(stack) > 0 {
+				buf.WriteByte(',')
+				writeJSONString(buf, f.Key+"_stack")
+				buf.WriteByte(':')
+				writeJSONValue(buf, stack)
+			}
+		}
+	}
+	if lc.static != nil {
+		buf.WriteString(lc.static.json)
+	}
+	buf.WriteString("}\n")
+
+	return buf.Bytes()
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+// writeJSONValue writes v as JSON, errors as their message, or an array of
+// messages when they wrap others, and values which can not be marshaled
+// formatted with fmt.Sprint.
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		if chain := errorChain(err); len(chain) > 1 {
+			v = chain
+		} else {
+			v = err.Error()
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeJSONString(buf, fmt.Sprint(v))
+		return
+	}
+	buf.Write(b)
+}
+
+// FrameFormatter prefixes the lines rendered by Formatter with their length
+// as 4 bytes in big-endian order, so a receiver, e.g. over TCP, can split
+// them even when messages contain newlines. The frame is written in a
+// single Write.
+type FrameFormatter struct {
+	Formatter Formatter
+}
+
+func (f FrameFormatter) Format(lc LogContent) []byte {
+	line := f.Formatter.Format(lc)
+	frame := make([]byte, 4+len(line))
+	binary.BigEndian.PutUint32(frame, uint32(len(line)))
+	copy(frame[4:], line)
+	return frame
+}
@@ -0,0 +1,373 @@
+package xlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogfmtFormatterQuoting(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{"hello", `msg=hello`},
+		{"", `msg=""`},
+		{"hello world", `msg="hello world"`},
+		{"a=b", `msg="a=b"`},
+		{`say "hi"`, `msg="say \"hi\""`},
+		{"two\nlines", `msg="two\nlines"`},
+		{"tab\there", `msg="tab\there"`},
+		{"héllo", `msg=héllo`},
+	}
+
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	for _, tt := range tests {
+		got := string(LogfmtFormatter{}.Format(LogContent{
+			Time:  ts,
+			Level: LevelInfo,
+			File:  "x.go",
+			Line:  12,
+			Args:  []interface{}{tt.msg},
+		}))
+		want := "time=2020-01-02T03:04:05.000006Z level=info file=x.go:12 " + tt.want + "\n"
+		if got != want {
+			t.Errorf("Format(%q) = %q, want %q", tt.msg, got, want)
+		}
+	}
+}
+
+func TestLogfmtFormatterKeys(t *testing.T) {
+	f := LogfmtFormatter{TimeKey: "ts", CallerKey: "caller"}
+	got := string(f.Format(LogContent{
+		Time:  time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC),
+		Level: LevelWarn,
+		File:  "x.go",
+		Line:  12,
+		Args:  []interface{}{"hello world"},
+	}))
+	if want := "ts=2020-01-02T03:04:05.000006Z level=warn caller=x.go:12 msg=\"hello world\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTextFormatterMicroseconds(t *testing.T) {
+	tests := []struct {
+		flag int
+		nsec int
+		want string
+	}{
+		{log.Ldate | log.Ltime, 123456789, "2020/01/02 03:04:05 [info]"},
+		{log.Ldate | log.Ltime | log.Lmicroseconds, 123456789, "2020/01/02 03:04:05.123456 [info]"},
+		{log.Ldate | log.Ltime | log.Lmicroseconds, 7000, "2020/01/02 03:04:05.000007 [info]"},
+		{log.Ldate | log.Ltime | log.Lmicroseconds, 0, "2020/01/02 03:04:05.000000 [info]"},
+	}
+	for _, tt := range tests {
+		got := string(TextFormatter{}.Format(LogContent{
+			Time:  time.Date(2020, 1, 2, 3, 4, 5, tt.nsec, time.UTC),
+			Level: LevelInfo,
+			flag:  tt.flag,
+		}))
+		if !strings.HasPrefix(got, tt.want+" ") {
+			t.Errorf("flag %d, %dns: got %q, want prefix %q", tt.flag, tt.nsec, got, tt.want)
+		}
+	}
+}
+
+func TestShortLevel(t *testing.T) {
+	tests := []struct {
+		level LogLevel
+		want  string
+	}{
+		{LevelDebug, "D"},
+		{LevelInfo, "I"},
+		{LevelWarn, "W"},
+		{LevelError, "E"},
+		{LevelPanic, "P"},
+		{LevelFatal, "F"},
+	}
+	for _, tt := range tests {
+		got := string(TextFormatter{}.Format(LogContent{
+			Time:       time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			Level:      tt.level,
+			File:       "x.go",
+			Line:       1,
+			Args:       []interface{}{"hi"},
+			flag:       log.Ldate | log.Ltime,
+			shortLevel: true,
+		}))
+		if want := "2020/01/02 03:04:05 " + tt.want + " x.go:1 hi\n"; got != want {
+			t.Errorf("%v: got %q, want %q", tt.level, got, want)
+		}
+	}
+}
+
+func TestFieldSeparator(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{
+		Flag:           log.Ldate | log.Ltime | log.Lshortfile,
+		Now:            func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) },
+		FieldSeparator: "\t",
+	})
+	_, _, line, _ := runtime.Caller(0)
+	logger.InfoID("req-1", "hello world")
+	logger.Close()
+
+	want := fmt.Sprintf("2020/01/02 03:04:05\t[info]\t[req-1]\tformatter_test.go:%d\thello world\n", line+1)
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestJSONFormatter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{
+		Formatter:    JSONFormatter{},
+		StaticFields: map[string]string{"app": "api"},
+	})
+	_, _, line, _ := runtime.Caller(0)
+	logger.Infow("say \"hi\"", "status", 200, "err", errors.New("boom"))
+	logger.Close()
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"level":  "info",
+		"file":   "formatter_test.go",
+		"line":   float64(line + 1),
+		"msg":    `say "hi"`,
+		"status": float64(200),
+		"err":    "boom",
+		"app":    "api",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %#v, want %#v", k, got[k], v)
+		}
+	}
+}
+
+// staticFormatter renders the static fields of a line only.
+type staticFormatter struct{}
+
+func (staticFormatter) Format(lc LogContent) []byte {
+	var b []byte
+	for _, f := range lc.StaticFields() {
+		b = append(b, fmt.Sprintf("%s=%v ", f.Key, f.Interface())...)
+	}
+	return append(b, '\n')
+}
+
+func TestLogContentStaticFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{
+		Formatter:    staticFormatter{},
+		StaticFields: map[string]string{"host": "h1", "app": "api"},
+	})
+	logger.Info("hi")
+	logger.Close()
+
+	if got, want := buf.String(), "app=api host=h1 \n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	for encoding, prefix := range map[string]string{
+		"":       "20",
+		"TEXT":   "20",
+		"logfmt": "time=",
+		"json":   `{"ts":`,
+	} {
+		var buf bytes.Buffer
+		logger := NewLogger(&buf, Options{Encoding: encoding})
+		logger.Info("hello")
+		logger.Close()
+		if !strings.HasPrefix(buf.String(), prefix) {
+			t.Errorf("encoding %q: got %q, want prefix %q", encoding, buf.String(), prefix)
+		}
+	}
+
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Encoding: "json"})
+	logger.Info("hello")
+	logger.Close()
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%q: %v", buf.String(), err)
+	}
+	if len(line) != 4 || line["level"] != "info" || line["msg"] != "hello" {
+		t.Errorf("json line = %q", buf.String())
+	}
+	if ts, _ := line["ts"].(string); !strings.HasPrefix(ts, "20") {
+		t.Errorf("ts = %v", line["ts"])
+	}
+	if caller, _ := line["caller"].(string); !strings.HasPrefix(caller, "formatter_test.go:") {
+		t.Errorf("caller = %v", line["caller"])
+	}
+
+	if _, err := encodingFormatter("xml"); err == nil {
+		t.Error("no error for an unknown encoding")
+	}
+}
+
+// TestEncodingErrorFromFile runs with -race: the error of the encoding is
+// logged once the file state is set.
+func TestEncodingErrorFromFile(t *testing.T) {
+	logFile := filepath.Join(tempDir(t), "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{Encoding: "bogus", MaxSize: 1, FlushOnSignal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hello")
+	logger.Close()
+
+	if lines := readLines(t, logFile); len(lines) != 2 || !strings.Contains(lines[0], "xlog: ") || !strings.Contains(lines[0], "bogus") {
+		t.Errorf("got %q", lines)
+	}
+}
+
+func TestRender(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{
+		Level: LevelError,
+		Now:   func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) },
+	})
+	_, _, line, _ := runtime.Caller(0)
+	got := string(logger.Render(LevelInfo, "hello %s", "world"))
+	logger.Close()
+
+	want := fmt.Sprintf("2020/01/02 03:04:05.000000 [info] formatter_test.go:%d hello world\n", line+1)
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Render logged %q", buf.String())
+	}
+}
+
+// readFrames splits the frames written by FrameFormatter.
+func readFrames(r io.Reader) ([]string, error) {
+	var frames []string
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			return frames, nil
+		} else if err != nil {
+			return nil, err
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+		frames = append(frames, string(frame))
+	}
+}
+
+func TestFrameFormatter(t *testing.T) {
+	for _, f := range []Formatter{TextFormatter{}, JSONFormatter{}} {
+		var buf bytes.Buffer
+		logger := NewLogger(&buf, Options{Formatter: FrameFormatter{Formatter: f}})
+		logger.Info("first\nline")
+		logger.Info("second")
+		logger.Close()
+
+		frames, err := readFrames(&buf)
+		if err != nil {
+			t.Fatalf("%T: %v", f, err)
+		}
+		if len(frames) != 2 || !strings.Contains(frames[0], "first") || !strings.Contains(frames[1], "second") {
+			t.Errorf("%T: got frames %q", f, frames)
+		}
+	}
+}
+
+func TestTrailingNewline(t *testing.T) {
+	tests := []struct {
+		msg    string
+		fields []Field
+		want   string
+	}{
+		{"hi", nil, "hi\n"},
+		{"hi\n", nil, "hi\n"},
+		{"hi\n\n", nil, "hi\n\n"},
+		{"", nil, "\n"},
+		{"hi\n", []Field{{Key: "k", Value: "v"}}, "hi\n k=v\n"},
+	}
+	for _, tt := range tests {
+		got := string(TextFormatter{}.Format(LogContent{
+			Level:  LevelInfo,
+			File:   "x.go",
+			Line:   1,
+			Args:   []interface{}{tt.msg},
+			Fields: tt.fields,
+		}))
+		if !strings.HasSuffix(got, " x.go:1 "+tt.want) || strings.Count(got, "\n") != strings.Count(tt.want, "\n") {
+			t.Errorf("%q: got %q, want suffix %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+// binaryEncoder writes a level byte and a length-prefixed message.
+func binaryEncoder(lc LogContent, w io.Writer) error {
+	msg := lc.Message()
+	header := []byte{byte(lc.Level), byte(len(msg))}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, msg)
+	return err
+}
+
+func TestEncoder(t *testing.T) {
+	var out, extra bytes.Buffer
+	logger := NewLogger(&out, Options{Formatter: EncoderFunc(binaryEncoder)})
+	logger.AddWriter(&extra, LevelDebug)
+	logger.Warn("hi")
+	logger.Close()
+
+	want := "\x02\x02hi"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if got := extra.String(); got != want {
+		t.Errorf("extra writer = %q, want %q", got, want)
+	}
+}
+
+// BenchmarkJSONStaticFields compares the static fields, rendered once per
+// logger, to the same fields rendered for every line.
+func BenchmarkJSONStaticFields(b *testing.B) {
+	fields := map[string]string{"app": "api", "env": "prod", "host": "web-1", "version": "1.2.3"}
+	lc := LogContent{Time: time.Now(), Level: LevelInfo, File: "x.go", Line: 1, Args: []interface{}{"hello"}}
+
+	b.Run("static", func(b *testing.B) {
+		lc := lc
+		lc.static = newStaticFields(fields)
+		lc.buf = new(bytes.Buffer)
+		for i := 0; i < b.N; i++ {
+			JSONFormatter{}.Format(lc)
+		}
+	})
+	b.Run("per-line", func(b *testing.B) {
+		lc := lc
+		for k, v := range fields {
+			lc.Fields = append(lc.Fields, Field{Key: k, Value: v})
+		}
+		lc.buf = new(bytes.Buffer)
+		for i := 0; i < b.N; i++ {
+			JSONFormatter{}.Format(lc)
+		}
+	})
+}
@@ -1,3 +1,5 @@
 module github.com/gnenux/xlog
 
 go 1.14
+
+require go.uber.org/goleak v1.1.12
@@ -0,0 +1,88 @@
+package xlog
+
+import (
+	"bytes"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// goroutineContexts holds the fields of SetGoroutineContext by goroutine ID.
+type goroutineContexts struct {
+	n      int32 // len(fields), read atomically to skip the lookup
+	mu     sync.Mutex
+	fields map[uint64][]Field
+}
+
+// SetGoroutineContext makes every line the calling goroutine logs through l
+// or the loggers sharing its output start with fields, e.g. the ID of a
+// worker in legacy code which cannot pass a logger around. It replaces the
+// fields set before.
+//
+// The fields are kept until ClearGoroutineContext is called from the same
+// goroutine: a goroutine exiting without it leaks them, and a later
+// goroutine reusing its ID would inherit them.
+func (l *Logger) SetGoroutineContext(fields map[string]string) {
+	if l.nop {
+		return
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fs := make([]Field, len(keys))
+	for i, k := range keys {
+		fs[i] = Field{Key: k, Value: fields[k]}
+	}
+
+	g := &l.goroutines
+	id := goroutineID()
+	g.mu.Lock()
+	if g.fields == nil {
+		g.fields = make(map[uint64][]Field)
+	}
+	g.fields[id] = fs
+	atomic.StoreInt32(&g.n, int32(len(g.fields)))
+	g.mu.Unlock()
+}
+
+// ClearGoroutineContext removes the fields of SetGoroutineContext for the
+// calling goroutine.
+func (l *Logger) ClearGoroutineContext() {
+	if l.nop {
+		return
+	}
+	g := &l.goroutines
+	g.mu.Lock()
+	delete(g.fields, goroutineID())
+	atomic.StoreInt32(&g.n, int32(len(g.fields)))
+	g.mu.Unlock()
+}
+
+// lookup returns the fields of the calling goroutine, nil without any.
+func (g *goroutineContexts) lookup() []Field {
+	if atomic.LoadInt32(&g.n) == 0 {
+		return nil
+	}
+	id := goroutineID()
+	g.mu.Lock()
+	fs := g.fields[id]
+	g.mu.Unlock()
+	return fs
+}
+
+// goroutineID parses the ID of the calling goroutine from the header of its
+// stack, "goroutine 18 [running]:", the runtime exports it no other way.
+func goroutineID() uint64 {
+	var b [64]byte
+	s := b[:runtime.Stack(b[:], false)]
+	s = bytes.TrimPrefix(s, []byte("goroutine "))
+	if i := bytes.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+	id, _ := strconv.ParseUint(string(s), 10, 64)
+	return id
+}
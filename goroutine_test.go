@@ -0,0 +1,58 @@
+package xlog
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGoroutineContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			logger.SetGoroutineContext(map[string]string{"worker": fmt.Sprint(worker)})
+			defer logger.ClearGoroutineContext()
+			logger.Infof("job of %d", worker)
+			logger.Infow("done", "worker_seen", worker)
+		}(i)
+	}
+	wg.Wait()
+	logger.Info("main")
+	logger.Close()
+
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		var worker int
+		switch {
+		case strings.HasSuffix(line, " main"):
+			if strings.Contains(line, "worker=") {
+				t.Errorf("line %q of main has a worker", line)
+			}
+			continue
+		case strings.Contains(line, " job of "):
+			fmt.Sscanf(line[strings.Index(line, " job of ")+8:], "%d", &worker)
+		default:
+			fmt.Sscanf(line[strings.Index(line, "worker_seen=")+12:], "%d", &worker)
+		}
+		if !strings.Contains(line, fmt.Sprintf(" worker=%d", worker)) {
+			t.Errorf("line %q lacks worker=%d", line, worker)
+		}
+	}
+	if n := len(logger.goroutines.fields); n != 0 {
+		t.Errorf("%d contexts left after clearing", n)
+	}
+}
+
+func TestGoroutineID(t *testing.T) {
+	ids := make(chan uint64)
+	go func() { ids <- goroutineID() }()
+	if a, b := goroutineID(), <-ids; a == 0 || b == 0 || a == b {
+		t.Errorf("got IDs %d and %d", a, b)
+	}
+}
@@ -0,0 +1,273 @@
+package xlog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPOptions configures an HTTPWriter.
+type HTTPOptions struct {
+	// BatchSize is the number of lines which triggers a request, 100 by
+	// default.
+	BatchSize int
+	// MaxAge is how long the first line of a batch waits for the batch to
+	// fill before it is sent anyway, a second by default.
+	MaxAge time.Duration
+	// Retries is how many times a request failing on the network or with
+	// a 429 or 5xx status is sent again, waiting from 100ms and twice
+	// longer each time. Zero means 3, a negative value no retry.
+	Retries int
+	// Header is added to each request, e.g. an Authorization token.
+	Header http.Header
+	// JSONArray sends a batch as a JSON array rather than as NDJSON, one
+	// line per entry. It expects lines rendered by JSONFormatter.
+	JSONArray bool
+	// QueueSize is the number of lines waiting to be sent, 1024 by
+	// default, the oldest is dropped when it is full.
+	QueueSize int
+	// Client sends the requests, a client with a 10 second timeout when
+	// nil.
+	Client *http.Client
+	// Encode, when set, builds the body of a batch in place of NDJSON, e.g.
+	// in the format of a log store. JSONArray is then ignored.
+	Encode func(batch [][]byte) []byte
+	// ContentType is the Content-Type of the requests, by default
+	// application/x-ndjson, or application/json with JSONArray.
+	ContentType string
+}
+
+// httpTimeout bounds the requests of the default client of HTTPWriter, and
+// how long Close waits for the last ones.
+const httpTimeout = 10 * time.Second
+
+// HTTPWriter POSTs batches of lines to an HTTP endpoint, as an output of a
+// logger:
+//
+//	w := xlog.NewHTTPWriter("https://logs.example.com/ingest", xlog.HTTPOptions{
+//		Header: http.Header{"Authorization": {"Bearer " + token}},
+//	})
+//	logger.AddSink(w, xlog.JSONFormatter{}, xlog.LevelInfo)
+//
+// Like NetWriter, writes only queue the lines, a goroutine sends them.
+// The lines of a batch failing for good are counted in Dropped.
+type HTTPWriter struct {
+	url  string
+	opts HTTPOptions
+
+	dropped uint64
+	closed  int32
+	queue   chan []byte
+	quit    chan struct{}
+	done    chan struct{}
+	// ctx is cancelled by Close when the last requests take too long.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewHTTPWriter returns an HTTPWriter sending to url.
+func NewHTTPWriter(url string, opts HTTPOptions) *HTTPWriter {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = time.Second
+	}
+	if opts.Retries == 0 {
+		opts.Retries = 3
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultNetQueue
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: httpTimeout}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &HTTPWriter{
+		url:    url,
+		opts:   opts,
+		queue:  make(chan []byte, opts.QueueSize),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go w.run()
+	return w
+}
+
+// Write queues a copy of p, it fails only once w is closed.
+func (w *HTTPWriter) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&w.closed) == 1 {
+		return 0, ErrClosed
+	}
+	pushLine(w.queue, p, &w.dropped)
+	return len(p), nil
+}
+
+// Dropped returns the number of lines dropped on a full queue or with a
+// batch which could not be sent.
+func (w *HTTPWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Close sends the lines still queued and stops w. The requests still
+// running after the timeout of the client, or 10 seconds without one, are
+// cancelled and their lines dropped.
+func (w *HTTPWriter) Close() error {
+	if !atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
+		return ErrClosed
+	}
+	close(w.quit)
+	timeout := w.opts.Client.Timeout
+	if timeout <= 0 {
+		timeout = httpTimeout
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-w.done:
+	case <-t.C:
+		w.cancel()
+		<-w.done
+	}
+	w.cancel()
+	return nil
+}
+
+func (w *HTTPWriter) run() {
+	defer close(w.done)
+
+	var batch [][]byte
+	var timer *time.Timer
+	var expired <-chan time.Time
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		if len(batch) > 0 {
+			w.send(batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case line := <-w.queue:
+			batch = append(batch, line)
+			if len(batch) == 1 {
+				timer = time.NewTimer(w.opts.MaxAge)
+				expired = timer.C
+			}
+			if len(batch) >= w.opts.BatchSize {
+				flush()
+			}
+		case <-expired:
+			flush()
+		case <-w.quit:
+			for {
+				select {
+				case line := <-w.queue:
+					batch = append(batch, line)
+					if len(batch) >= w.opts.BatchSize {
+						flush()
+					}
+					continue
+				default:
+				}
+				break
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// send posts batch, retrying as HTTPOptions.Retries tells. Once w is
+// closed, it retries without waiting.
+func (w *HTTPWriter) send(batch [][]byte) {
+	body := w.body(batch)
+	backoff := netMinBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= w.opts.Retries || w.ctx.Err() != nil {
+			atomic.AddUint64(&w.dropped, uint64(len(batch)))
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-w.quit:
+		}
+		backoff *= 2
+	}
+}
+
+// body joins the lines of batch as NDJSON or as a JSON array, unless
+// HTTPOptions.Encode is set.
+func (w *HTTPWriter) body(batch [][]byte) []byte {
+	if w.opts.Encode != nil {
+		return w.opts.Encode(batch)
+	}
+	var buf bytes.Buffer
+	if w.opts.JSONArray {
+		buf.WriteByte('[')
+	}
+	for i, line := range batch {
+		if w.opts.JSONArray {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(bytes.TrimRight(line, "\r\n"))
+			continue
+		}
+		buf.Write(line)
+		if !bytes.HasSuffix(line, []byte{'\n'}) {
+			buf.WriteByte('\n')
+		}
+	}
+	if w.opts.JSONArray {
+		buf.WriteByte(']')
+	}
+	return buf.Bytes()
+}
+
+// post sends body once, it reports whether a failure is worth a retry.
+func (w *HTTPWriter) post(body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range w.opts.Header {
+		req.Header[k] = v
+	}
+	if req.Header.Get("Content-Type") == "" {
+		if w.opts.ContentType != "" {
+			req.Header.Set("Content-Type", w.opts.ContentType)
+		} else if w.opts.JSONArray {
+			req.Header.Set("Content-Type", "application/json")
+		} else {
+			req.Header.Set("Content-Type", "application/x-ndjson")
+		}
+	}
+
+	resp, err := w.opts.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("xlog: POST %s: %s", w.url, resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
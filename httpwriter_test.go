@@ -0,0 +1,152 @@
+package xlog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ingestServer records the bodies posted to it, answering with the
+// statuses in turn, then 200.
+type ingestServer struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (s *ingestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies = append(s.bodies, string(b))
+	s.headers = append(s.headers, r.Header)
+	if len(s.statuses) > 0 {
+		w.WriteHeader(s.statuses[0])
+		s.statuses = s.statuses[1:]
+	}
+}
+
+func TestHTTPWriter(t *testing.T) {
+	s := new(ingestServer)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	w := NewHTTPWriter(srv.URL, HTTPOptions{
+		BatchSize: 2,
+		MaxAge:    time.Hour,
+		Header:    http.Header{"Authorization": {"Bearer t"}},
+	})
+	for _, line := range []string{"{\"a\":1}\n", "{\"b\":2}\n", "{\"c\":3}\n"} {
+		w.Write([]byte(line))
+	}
+	w.Close()
+
+	want := []string{"{\"a\":1}\n{\"b\":2}\n", "{\"c\":3}\n"}
+	if len(s.bodies) != len(want) || s.bodies[0] != want[0] || s.bodies[1] != want[1] {
+		t.Fatalf("got bodies %q, want %q", s.bodies, want)
+	}
+	if h := s.headers[0]; h.Get("Authorization") != "Bearer t" || h.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("got headers %v", h)
+	}
+}
+
+func TestHTTPWriterJSONArray(t *testing.T) {
+	s := new(ingestServer)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	w := NewHTTPWriter(srv.URL, HTTPOptions{JSONArray: true, MaxAge: 10 * time.Millisecond})
+	w.Write([]byte("{\"a\":1}\n"))
+	w.Write([]byte("{\"b\":2}\n"))
+	// sent once the batch is old enough, before Close
+	for i := 0; i < 500; i++ {
+		s.mu.Lock()
+		n := len(s.bodies)
+		s.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.Close()
+
+	if len(s.bodies) != 1 || s.bodies[0] != `[{"a":1},{"b":2}]` {
+		t.Fatalf("got bodies %q", s.bodies)
+	}
+	if got := s.headers[0].Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+}
+
+func TestHTTPWriterEncode(t *testing.T) {
+	s := new(ingestServer)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	w := NewHTTPWriter(srv.URL, HTTPOptions{
+		MaxAge: time.Hour,
+		Encode: func(batch [][]byte) []byte {
+			return bytes.Join(batch, []byte("|"))
+		},
+		ContentType: "text/x-test",
+	})
+	w.Write([]byte("a"))
+	w.Write([]byte("b"))
+	w.Close()
+
+	if len(s.bodies) != 1 || s.bodies[0] != "a|b" {
+		t.Fatalf("got bodies %q", s.bodies)
+	}
+	if got := s.headers[0].Get("Content-Type"); got != "text/x-test" {
+		t.Errorf("Content-Type = %q", got)
+	}
+}
+
+func TestHTTPWriterRetry(t *testing.T) {
+	for _, tt := range []struct {
+		statuses []int
+		requests int
+		dropped  uint64
+	}{
+		{[]int{http.StatusServiceUnavailable}, 2, 0},
+		{[]int{500, 500, 500, 500}, 4, 1},
+		{[]int{http.StatusBadRequest}, 1, 1},
+	} {
+		s := &ingestServer{statuses: tt.statuses}
+		srv := httptest.NewServer(s)
+		w := NewHTTPWriter(srv.URL, HTTPOptions{})
+		w.Write([]byte("line\n"))
+		w.Close()
+		srv.Close()
+
+		if len(s.bodies) != tt.requests || w.Dropped() != tt.dropped {
+			t.Errorf("statuses %v: got %d requests and %d dropped, want %d and %d",
+				tt.statuses, len(s.bodies), w.Dropped(), tt.requests, tt.dropped)
+		}
+	}
+}
+
+func TestHTTPWriterCloseCancels(t *testing.T) {
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stop
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	w := NewHTTPWriter(srv.URL, HTTPOptions{MaxAge: time.Millisecond, Retries: 10, Client: &http.Client{Timeout: 100 * time.Millisecond}})
+	w.Write([]byte("line\n"))
+	start := time.Now()
+	w.Close()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Close took %v", d)
+	}
+	if w.Dropped() != 1 {
+		t.Errorf("dropped %d lines, want 1", w.Dropped())
+	}
+}
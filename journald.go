@@ -0,0 +1,113 @@
+package xlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// journalSocket is where journald receives entries in its native protocol.
+var journalSocket = "/run/systemd/journal/socket"
+
+// JournalFormatter renders lines as entries of the native protocol of
+// systemd-journald, for a sink opened by DialJournal: MESSAGE, PRIORITY,
+// the LogLevel.Severity of the line, CODE_FILE, CODE_LINE and
+// SYSLOG_IDENTIFIER, SyslogIdentifier or the program name, then fields and
+// static fields with their keys upper-cased, e.g. REQUEST_ID for
+// "request-id", and the correlation ID as XLOG_ID.
+type JournalFormatter struct {
+	SyslogIdentifier string
+}
+
+func (f JournalFormatter) Format(lc LogContent) []byte {
+	buf := lc.buffer()
+
+	writeJournalField(buf, "MESSAGE", strings.TrimSuffix(lc.Message(), "\n"))
+	writeJournalField(buf, "PRIORITY", strconv.Itoa(lc.Level.Severity()))
+	if lc.File != "" {
+		writeJournalField(buf, "CODE_FILE", lc.File)
+		writeJournalField(buf, "CODE_LINE", strconv.Itoa(lc.Line))
+	}
+	identifier := f.SyslogIdentifier
+	if identifier == "" {
+		identifier = filepath.Base(os.Args[0])
+	}
+	writeJournalField(buf, "SYSLOG_IDENTIFIER", identifier)
+	if lc.ID != "" {
+		writeJournalField(buf, "XLOG_ID", lc.ID)
+	}
+	for _, field := range lc.Fields {
+		v := field.value()
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		writeJournalField(buf, journalKey(field.Key), fmt.Sprint(v))
+	}
+	if lc.static != nil {
+		for i, k := range lc.static.keys {
+			writeJournalField(buf, journalKey(k), lc.static.values[i])
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// writeJournalField writes KEY=value, or for a value spanning lines KEY,
+// its length as 8 bytes in little-endian order and the value.
+func writeJournalField(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	if strings.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	buf.Write(size[:])
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalKey turns key into a valid journal field name: upper-case
+// letters, digits and underscores, not starting with an underscore, which
+// journald keeps for its own fields, nor a digit, and 64 bytes at most.
+func journalKey(key string) string {
+	b := make([]byte, 0, len(key))
+	for i := 0; i < len(key) && len(b) < 64; i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+			c -= 'a' - 'A'
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		default:
+			c = '_'
+		}
+		if len(b) == 0 && (c == '_' || c >= '0' && c <= '9') {
+			continue
+		}
+		b = append(b, c)
+	}
+	if len(b) == 0 {
+		return "FIELD"
+	}
+	return string(b)
+}
+
+// DialJournal connects to the socket of systemd-journald, for a sink
+// rendered by JournalFormatter:
+//
+//	w, err := xlog.DialJournal()
+//	logger.AddSink(w, xlog.JournalFormatter{}, xlog.LevelDebug)
+//
+// Each entry is a datagram, so an entry larger than the socket allows,
+// usually a few hundred kilobytes, fails to write.
+func DialJournal() (io.WriteCloser, error) {
+	return DialSyslog("unixgram", journalSocket)
+}
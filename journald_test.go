@@ -0,0 +1,60 @@
+package xlog
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalFormatter(t *testing.T) {
+	got := string(JournalFormatter{SyslogIdentifier: "api"}.Format(LogContent{
+		Level:  LevelError,
+		ID:     "req-1",
+		File:   "x.go",
+		Line:   12,
+		Args:   []interface{}{"two\nlines"},
+		Fields: []Field{String("request-id", "42"), Int("_status", 500)},
+	}))
+	want := "MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\n" +
+		"PRIORITY=3\nCODE_FILE=x.go\nCODE_LINE=12\nSYSLOG_IDENTIFIER=api\n" +
+		"XLOG_ID=req-1\nREQUEST_ID=42\nSTATUS=500\n"
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestDialJournal(t *testing.T) {
+	path := filepath.Join(tempDir(t), "journal.socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	defer func(socket string) { journalSocket = socket }(journalSocket)
+	journalSocket = path
+
+	w, err := DialJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	logger := NewLogger(ioutil.Discard, Options{})
+	logger.AddSink(w, JournalFormatter{}, LevelDebug)
+	logger.Info("hello")
+	logger.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 1024)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "MESSAGE=hello\nPRIORITY=6\n"
+	if got := string(b[:n]); !strings.HasPrefix(got, want) || !strings.Contains(got, "SYSLOG_IDENTIFIER="+filepath.Base(os.Args[0])+"\n") {
+		t.Errorf("got %q", got)
+	}
+}
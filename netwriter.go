@@ -0,0 +1,198 @@
+package xlog
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// netMinBackoff and netMaxBackoff bound the wait between two attempts
+	// to connect, doubled after each failure.
+	netMinBackoff = 100 * time.Millisecond
+	netMaxBackoff = 30 * time.Second
+	// netTimeout bounds a connection attempt and a write.
+	netTimeout = 5 * time.Second
+	// defaultNetQueue is the number of lines a NetWriter holds by default.
+	defaultNetQueue = 1024
+)
+
+// NetWriter streams lines to a TCP or UDP endpoint, e.g. a central
+// collector, as an output of a logger:
+//
+//	w := xlog.NewNetWriter("tcp", "collector:5170", 0)
+//	logger.AddSink(w, xlog.JSONFormatter{}, xlog.LevelInfo)
+//
+// Writes never wait for the network: lines are queued, up to queueSize,
+// 1024 when zero, and sent by a goroutine which connects in the
+// background and reconnects after a failure, waiting from 100ms up to 30s
+// between attempts until a line goes through. When the queue is full the oldest line is dropped, see
+// Dropped. Over UDP each line is a datagram.
+type NetWriter struct {
+	network string
+	addr    string
+
+	dropped uint64
+	// mu orders Write and Close, so no line is queued once run drained
+	// the queue.
+	mu     sync.RWMutex
+	closed bool
+	queue  chan []byte
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+// NewNetWriter returns a NetWriter sending to addr over network, it starts
+// connecting right away.
+func NewNetWriter(network, addr string, queueSize int) *NetWriter {
+	if queueSize <= 0 {
+		queueSize = defaultNetQueue
+	}
+	w := &NetWriter{
+		network: network,
+		addr:    addr,
+		queue:   make(chan []byte, queueSize),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues a copy of p, it fails only once w is closed.
+func (w *NetWriter) Write(p []byte) (int, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return 0, ErrClosed
+	}
+	pushLine(w.queue, p, &w.dropped)
+	return len(p), nil
+}
+
+// pushLine queues a copy of p, dropping the oldest line of a full queue
+// and counting it in dropped.
+func pushLine(queue chan []byte, p []byte, dropped *uint64) {
+	line := append([]byte(nil), p...)
+	for {
+		select {
+		case queue <- line:
+			return
+		default:
+		}
+		select {
+		case <-queue:
+			atomic.AddUint64(dropped, 1)
+		default:
+		}
+	}
+}
+
+// Dropped returns the number of lines dropped on a full queue or left
+// unsent at Close.
+func (w *NetWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Close sends the lines still queued if connected, giving up on the first
+// failure, and closes the connection.
+func (w *NetWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	w.closed = true
+	w.mu.Unlock()
+	close(w.quit)
+	<-w.done
+	return nil
+}
+
+func (w *NetWriter) run() {
+	defer close(w.done)
+
+	var conn net.Conn
+	backoff := netMinBackoff
+	// wait waits out the backoff after a failure, it reports false once w
+	// is closed meanwhile.
+	wait := func() bool {
+		select {
+		case <-time.After(backoff):
+		case <-w.quit:
+			return false
+		}
+		if backoff *= 2; backoff > netMaxBackoff {
+			backoff = netMaxBackoff
+		}
+		return true
+	}
+
+	for {
+		var line []byte
+		select {
+		case line = <-w.queue:
+		case <-w.quit:
+			w.drain(conn)
+			return
+		}
+
+		for {
+			if conn == nil {
+				var err error
+				conn, err = net.DialTimeout(w.network, w.addr, netTimeout)
+				if err != nil {
+					conn = nil
+					if !wait() {
+						atomic.AddUint64(&w.dropped, 1)
+						w.drain(nil)
+						return
+					}
+					continue
+				}
+			}
+			conn.SetWriteDeadline(time.Now().Add(netTimeout))
+			if _, err := conn.Write(line); err != nil {
+				// the line is sent again on the next connection, after
+				// the backoff: a peer may accept connections and reset
+				// them right away
+				conn.Close()
+				conn = nil
+				if !wait() {
+					atomic.AddUint64(&w.dropped, 1)
+					w.drain(nil)
+					return
+				}
+				continue
+			}
+			// only a line through proves the peer healthy
+			backoff = netMinBackoff
+			break
+		}
+	}
+}
+
+// drain sends the queued lines over conn, once w is closed, and counts the
+// ones which could not be sent. No line is queued after it returns.
+func (w *NetWriter) drain(conn net.Conn) {
+	for {
+		select {
+		case line := <-w.queue:
+			if conn != nil {
+				conn.SetWriteDeadline(time.Now().Add(netTimeout))
+				if _, err := conn.Write(line); err == nil {
+					continue
+				}
+				conn.Close()
+				conn = nil
+			}
+			atomic.AddUint64(&w.dropped, 1)
+		default:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+	}
+}
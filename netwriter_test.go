@@ -0,0 +1,123 @@
+package xlog
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNetWriterReconnect(t *testing.T) {
+	// a free port, nothing listens on it yet
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	w := NewNetWriter("tcp", addr, 0)
+	defer w.Close()
+	w.Write([]byte("queued while down\n"))
+	time.Sleep(2 * netMinBackoff)
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	w.Write([]byte("after\n"))
+
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Second))
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"queued while down\n", "after\n"} {
+		got, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestNetWriterQueueFull(t *testing.T) {
+	w := NewNetWriter("tcp", "127.0.0.1:1", 2)
+	for _, line := range []string{"1", "2", "3", "4", "5"} {
+		w.Write([]byte(line))
+	}
+	w.Close()
+	// the line being sent counts too once Close gives up on it
+	if d := w.Dropped(); d != 5 {
+		t.Errorf("Dropped = %d, want 5", d)
+	}
+	if _, err := w.Write([]byte("late")); err != ErrClosed {
+		t.Errorf("Write after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestNetWriterResetPeer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	var accepted int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			// reset the connection right away
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		}
+	}()
+
+	w := NewNetWriter("tcp", ln.Addr().String(), 0)
+	// a line every 5ms, each connection takes one before it is reset
+	for i := 0; i < 100; i++ {
+		w.Write([]byte("line\n"))
+		time.Sleep(5 * time.Millisecond)
+	}
+	w.Close()
+	// the failed writes back off, from 100ms
+	if n := atomic.LoadInt32(&accepted); n > 20 {
+		t.Errorf("%d connections in 500ms", n)
+	}
+}
+
+func TestNetWriterCloseRace(t *testing.T) {
+	w := NewNetWriter("tcp", "127.0.0.1:1", 0)
+	var written uint64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := w.Write([]byte("x")); err != nil {
+					return
+				}
+				atomic.AddUint64(&written, 1)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	w.Close()
+	wg.Wait()
+	// every line queued is counted once it cannot be sent
+	if d, n := w.Dropped(), atomic.LoadUint64(&written); d != n {
+		t.Errorf("Dropped = %d, %d lines written", d, n)
+	}
+}
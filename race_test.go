@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package xlog
+
+// raceEnabled is set with the race detector, under which sync.Pool drops
+// items at random and allocation counts are meaningless.
+const raceEnabled = true
@@ -0,0 +1,153 @@
+package xlog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// rotated is called after each rotation, it has the rotated files swept
+// by the sweeper goroutine, or right away for a synchronous logger.
+func (c *core) rotated() {
+	if c.maxBackups <= 0 && c.maxAge <= 0 && !c.compress {
+		return
+	}
+	if c.synchronous {
+		c.sweepFiles()
+		return
+	}
+	select {
+	case c.sweep <- struct{}{}:
+	default:
+		// a sweep is pending already
+	}
+}
+
+// sweeper compresses the rotated files for Options.Compress and removes
+// those outliving MaxBackups and MaxAge, off the writer goroutine.
+func (c *core) sweeper() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.sweep:
+			c.sweepFiles()
+		case <-c.quit:
+			// the writer goroutine may still rotate while draining
+			<-c.stopped
+			select {
+			case <-c.sweep:
+				c.sweepFiles()
+			default:
+			}
+			return
+		}
+	}
+}
+
+// sweepFiles compresses the rotated files of fileName with Options.Compress,
+// then removes those beyond the newest maxBackups and those last written
+// more than maxAge ago, unless Options.BeforeRemove vetoes it.
+func (c *core) sweepFiles() {
+	// the live file is told apart by identity, its name may be relative
+	// or not clean like "./logs/app.log"
+	c.mu.Lock()
+	var current os.FileInfo
+	if c.f != nil {
+		current, _ = c.f.Stat()
+	}
+	c.mu.Unlock()
+
+	dir := filepath.Dir(c.fileName)
+	prefix := filepath.Base(c.fileName) + "."
+	entries, err := readDirNames(dir)
+	if err != nil {
+		c.errorf("%v", err)
+		return
+	}
+	type backup struct {
+		path string
+		info os.FileInfo
+	}
+	var backups []backup
+	for _, name := range entries {
+		path := filepath.Join(dir, name)
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		fi, err := os.Lstat(path)
+		if err != nil || !fi.Mode().IsRegular() || current != nil && os.SameFile(fi, current) {
+			continue
+		}
+		if c.compress && !strings.HasSuffix(name, ".gz") {
+			if path, err = gzipFile(path, fi, c.compressLevel); err != nil {
+				c.errorf("%v", err)
+				continue
+			}
+			if fi, err = os.Lstat(path); err != nil {
+				continue
+			}
+		}
+		backups = append(backups, backup{path, fi})
+	}
+	// newest first
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].info.ModTime().After(backups[j].info.ModTime())
+	})
+
+	now := c.now()
+	for i, b := range backups {
+		expired := c.maxBackups > 0 && i >= c.maxBackups ||
+			c.maxAge > 0 && now.Sub(b.info.ModTime()) > c.maxAge
+		if !expired || c.beforeRemove != nil && !c.beforeRemove(b.path) {
+			continue
+		}
+		if err := os.Remove(b.path); err != nil {
+			c.errorf("%v", err)
+		}
+	}
+}
+
+// gzipFile compresses path to path.gz, keeping its modification time for
+// MaxBackups and MaxAge, and removes it. It never overwrites an existing
+// path.gz.
+func gzipFile(path string, fi os.FileInfo, level int) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	gzPath := path + ".gz"
+	dst, err := os.OpenFile(gzPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fi.Mode())
+	if err != nil {
+		return "", err
+	}
+	zw, err := gzip.NewWriterLevel(dst, level)
+	if err == nil {
+		_, err = io.Copy(zw, src)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// a partial archive must not replace the file
+		os.Remove(gzPath)
+		return "", err
+	}
+	os.Chtimes(gzPath, fi.ModTime(), fi.ModTime())
+	return gzPath, os.Remove(path)
+}
+
+func readDirNames(dir string) ([]string, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return d.Readdirnames(-1)
+}
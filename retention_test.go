@@ -0,0 +1,244 @@
+package xlog
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rotatedFiles returns the names of the files in dir but the symlink.
+func rotatedFiles(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestMaxBackups(t *testing.T) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 0, 30, 0, 0, time.Local)}
+	dir := tempDir(t)
+	logFile := filepath.Join(dir, "test.log")
+	var vetoed []string
+	logger, err := NewLoggerFromFile(logFile, Options{
+		RotateInterval: Hourly,
+		Now:            clock.Now,
+		MaxBackups:     2,
+		BeforeRemove: func(path string) bool {
+			if strings.HasSuffix(path, ".2024010100") {
+				vetoed = append(vetoed, filepath.Base(path))
+				return false
+			}
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for h := 0; h < 5; h++ {
+		clock.Set(time.Date(2024, 1, 1, h, 30, 0, 0, time.Local))
+		logger.Info("hour")
+		logger.Flush()
+		// the modification times order the backups
+		waitFile(t, logFile+".20240101"+[]string{"00", "01", "02", "03", "04"}[h])
+		time.Sleep(10 * time.Millisecond)
+	}
+	logger.Close()
+
+	want := []string{"test.log.2024010100", "test.log.2024010102", "test.log.2024010103", "test.log.2024010104"}
+	if got := rotatedFiles(t, dir); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+	if len(vetoed) == 0 {
+		t.Error("BeforeRemove was not consulted")
+	}
+}
+
+// chdirTemp changes to a temporary directory for the test.
+func chdirTemp(t *testing.T) {
+	dir := tempDir(t)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestMaxBackupsRelativePath(t *testing.T) {
+	chdirTemp(t)
+	if err := os.Mkdir("logs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	clock := &testClock{t: time.Date(2024, 1, 1, 0, 30, 0, 0, time.Local)}
+	logFile := "./logs/test.log"
+	logger, err := NewLoggerFromFile(logFile, Options{RotateInterval: Hourly, Now: clock.Now, MaxBackups: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for h := 0; h < 3; h++ {
+		clock.Set(time.Date(2024, 1, 1, h, 30, 0, 0, time.Local))
+		logger.Info("hour")
+		logger.Flush()
+		waitFile(t, logFile+".20240101"+[]string{"00", "01", "02"}[h])
+		time.Sleep(10 * time.Millisecond)
+	}
+	logger.Close()
+
+	// the live file does not count as a backup
+	want := []string{"test.log.2024010101", "test.log.2024010102"}
+	if got := rotatedFiles(t, "logs"); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	dir := tempDir(t)
+	logFile := filepath.Join(dir, "test.log")
+	now := time.Now()
+	for name, age := range map[string]time.Duration{"test.log.old": 3 * time.Hour, "test.log.recent": 10 * time.Minute, "other.log.old": 3 * time.Hour} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger, err := NewLoggerFromFile(logFile, Options{RotateByRename: true, MaxAge: time.Hour, MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.maxSize = 1
+	logger.Info("rotated after this line")
+	logger.Close()
+
+	want := []string{"other.log.old", "test.log", "test.log." + now.Format("20060102"), "test.log.recent"}
+	if got := rotatedFiles(t, dir); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	dir := tempDir(t)
+	logFile := filepath.Join(dir, "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{RotateByRename: true, MaxSize: 1, Compress: true, CompressLevel: gzip.BestSpeed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.maxSize = 1
+	logger.Info("first")
+	logger.Info("second")
+	logger.Close()
+
+	backup := "test.log." + time.Now().Format("20060102")
+	want := []string{"test.log", backup + ".1.gz", backup + ".gz"}
+	if got := rotatedFiles(t, dir); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("got files %q, want %q", got, want)
+	}
+	f, err := os.Open(filepath.Join(dir, backup+".gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil || !strings.HasSuffix(string(b), " first\n") {
+		t.Errorf("got %q (%v), want the first line", b, err)
+	}
+}
+
+func TestCompressSizeRotations(t *testing.T) {
+	dir := tempDir(t)
+	logFile := filepath.Join(dir, "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{MaxSize: 1, Compress: true, Flag: log.LstdFlags})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.maxSize = 1
+	// the live file and the backups not compressed yet
+	plain := func() int {
+		n := 0
+		for _, name := range rotatedFiles(t, dir) {
+			if !strings.HasSuffix(name, ".gz") {
+				n++
+			}
+		}
+		return n
+	}
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		logger.Info(msg)
+		logger.Flush()
+		// each backup is compressed before the next rotation
+		for i := 0; i < 500 && plain() > 1; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	logger.Close()
+
+	var lines []string
+	for _, name := range rotatedFiles(t, dir) {
+		if !strings.HasSuffix(name, ".gz") {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(zr)
+		f.Close()
+		lines = append(lines, strings.TrimSuffix(string(b), "\n"))
+	}
+	if len(lines) != 4 {
+		t.Fatalf("got %d backups %q, want 4", len(lines), lines)
+	}
+}
+
+func TestCompressRelativePath(t *testing.T) {
+	chdirTemp(t)
+	clock := &testClock{t: time.Date(2024, 1, 1, 0, 30, 0, 0, time.Local)}
+	logger, err := NewLoggerFromFile("./test.log", Options{RotateInterval: Hourly, Now: clock.Now, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("first")
+	clock.Set(time.Date(2024, 1, 1, 1, 30, 0, 0, time.Local))
+	logger.Info("second")
+	logger.Flush()
+	waitFile(t, "test.log.2024010101")
+	logger.Info("third")
+	logger.Close()
+
+	// the live file is left uncompressed
+	b, err := ioutil.ReadFile("test.log.2024010101")
+	if err != nil || !strings.HasSuffix(string(b), " third\n") {
+		t.Errorf("got %q (%v), want the third line", b, err)
+	}
+	want := []string{"test.log.2024010100.gz", "test.log.2024010101"}
+	if got := rotatedFiles(t, "."); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
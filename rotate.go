@@ -0,0 +1,378 @@
+package xlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// RotateInterval is how often a logger created by NewLoggerFromFile
+// switches to a new file.
+type RotateInterval int
+
+const (
+	// Daily rotation names files like log.20240101.
+	Daily RotateInterval = iota
+	// Hourly rotation names files like log.2024010115.
+	Hourly
+	// Weekly rotation, on Mondays, names files after the ISO week like
+	// log.2024W01.
+	Weekly
+)
+
+// RotateEvery returns the RotateInterval of d, rounded down to seconds and
+// at least a second. The periods are counted from the Unix epoch and files
+// are named after their start like log.20240101153000.
+func RotateEvery(d time.Duration) RotateInterval {
+	secs := int(d / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	// negative, apart from the named intervals
+	return RotateInterval(-secs)
+}
+
+// seconds returns the length of an interval of RotateEvery, 0 for the
+// named ones.
+func (r RotateInterval) seconds() int64 {
+	if r < 0 {
+		return int64(-r)
+	}
+	return 0
+}
+
+// period identifies the rotation period containing t, it is compared for
+// every line so it must be cheap.
+func (r RotateInterval) period(t time.Time) int {
+	if secs := r.seconds(); secs > 0 {
+		return int(t.Unix() / secs)
+	}
+	if r == Weekly {
+		year, week := t.ISOWeek()
+		return year*100 + week
+	}
+	year, month, day := t.Date()
+	p := year*10000 + int(month)*100 + day
+	if r == Hourly {
+		p = p*100 + t.Hour()
+	}
+	return p
+}
+
+// length is the duration of a period, ignoring DST changes.
+func (r RotateInterval) length() time.Duration {
+	switch {
+	case r.seconds() > 0:
+		return time.Duration(r.seconds()) * time.Second
+	case r == Hourly:
+		return time.Hour
+	case r == Weekly:
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// next returns the start of the period after the one containing t.
+func (r RotateInterval) next(t time.Time) time.Time {
+	if secs := r.seconds(); secs > 0 {
+		return time.Unix((t.Unix()/secs+1)*secs, 0).In(t.Location())
+	}
+	year, month, day := t.Date()
+	switch r {
+	case Hourly:
+		return time.Date(year, month, day, t.Hour()+1, 0, 0, 0, t.Location())
+	case Weekly:
+		// days to the next Monday
+		return time.Date(year, month, day+7-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(year, month, day+1, 0, 0, 0, 0, t.Location())
+}
+
+// suffix is appended to the name of the file holding the period of t.
+func (r RotateInterval) suffix(t time.Time) string {
+	if secs := r.seconds(); secs > 0 {
+		return time.Unix(t.Unix()/secs*secs, 0).In(t.Location()).Format("20060102150405")
+	}
+	switch r {
+	case Hourly:
+		return fmt.Sprintf("%04d%02d%02d%02d", t.Year(), t.Month(), t.Day(), t.Hour())
+	case Weekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04dW%02d", year, week)
+	}
+	return fmt.Sprintf("%04d%02d%02d", t.Year(), t.Month(), t.Day())
+}
+
+// rotateTimer wakes the writer goroutine at the end of each period, so the
+// file is rotated on time even when nothing is logged, e.g. to have it
+// compressed.
+func (c *core) rotateTimer() {
+	defer c.wg.Done()
+	now := c.now()
+	timer := time.NewTimer(c.rotateInterval.next(now).Sub(now))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			select {
+			case c.wake <- struct{}{}:
+			case <-c.quit:
+				return
+			}
+			now := c.now()
+			timer.Reset(c.rotateInterval.next(now).Sub(now))
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// openDated opens the file of the period of t, named after logFile, and
+// points the symlink logFile at it.
+func openDated(logFile string, r RotateInterval, t time.Time) (*os.File, error) {
+	nowLogFile := logFile + "." + r.suffix(t)
+	f, err := createFile(nowLogFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := symlink(filepath.Base(nowLogFile), logFile); err != nil {
+		closeFile(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// openCurrent opens logFile for Options.RotateByRename. A file left from an
+// earlier period, e.g. before a restart, is renamed after it first, and a
+// symlink left by the default scheme is removed.
+func openCurrent(logFile string, r RotateInterval, t time.Time) (*os.File, error) {
+	if fi, err := os.Lstat(logFile); err == nil {
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(logFile); err != nil {
+				return nil, err
+			}
+		} else if r.period(fi.ModTime()) != r.period(t) {
+			if err := os.Rename(logFile, freeName(logFile+"."+r.suffix(fi.ModTime()))); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return createFile(logFile)
+}
+
+// renameFile renames the current file after the period of curTime and
+// reopens fileName, for Options.RotateByRename. It runs in the writer
+// goroutine with c.mu held throughout, so no line is written in between,
+// and closes the file before renaming it as Windows requires.
+func (c *core) renameFile() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		// detached by SetOutput
+		return
+	}
+
+	c.flushAhead()
+	closeFile(c.f)
+	renameErr := os.Rename(c.fileName, freeName(c.fileName+"."+c.rotateInterval.suffix(c.curTime)))
+	// reopen even if the rename failed, to keep logging
+	f, err := createFile(c.fileName)
+	// errorf could block on a full buffer in the writer goroutine
+	if renameErr != nil {
+		fmt.Fprintf(os.Stderr, "xlog: %v\n", renameErr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xlog: %v, logging to stderr\n", err)
+		c.setOut(os.Stderr)
+		c.f = nil
+		return
+	}
+	c.setOut(f)
+	c.f = f
+}
+
+// rotateSize moves the current file aside once it reaches Options.MaxSize
+// and reopens it. It runs in the writer goroutine, between two lines. With
+// RotateByRename the file is renamed after its period as by renameFile,
+// otherwise the dated file gets a number, e.g. log.20240101.1, .2 for the
+// next one and so on, the file being written keeping the dated name.
+func (c *core) rotateSize() {
+	if c.byRename {
+		c.renameFile()
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return
+	}
+	c.flushAhead()
+	name := c.f.Name()
+	closeFile(c.f)
+	renameErr := os.Rename(name, freeName(name))
+	f, err := createFile(name)
+	// errorf could block on a full buffer in the writer goroutine
+	if renameErr != nil {
+		fmt.Fprintf(os.Stderr, "xlog: %v\n", renameErr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xlog: %v, logging to stderr\n", err)
+		c.setOut(os.Stderr)
+		c.f = nil
+		return
+	}
+	c.setOut(f)
+	c.f = f
+}
+
+// Reopen closes the file of NewLoggerFromFile and opens it again under the
+// same name, once lines logged before the call are written, for external
+// tools like logrotate which move the file away and then signal the
+// process, see InstallReopenSignal. It does nothing for other outputs.
+func (l *Logger) Reopen() error {
+	if l.nop {
+		return nil
+	}
+	if atomic.LoadInt32(&l.closed) == 1 {
+		return ErrClosed
+	}
+	if err := l.Flush(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	f, err := createFile(l.f.Name())
+	if err != nil {
+		// keeps writing to the file moved away rather than losing lines
+		return err
+	}
+	l.setOut(f)
+	closeFile(l.f)
+	l.f = f
+	l.fallback = false
+	l.writeFailures = 0
+	return nil
+}
+
+// countingWriter counts the bytes written to w, for Options.MaxSize.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// freeName returns name, or name.1, name.2... if it exists, so a rotation
+// never overwrites an earlier file. A name whose compressed file exists is
+// taken as well, Options.Compress leaving only that one.
+func freeName(name string) string {
+	free := name
+	for i := 1; ; i++ {
+		if !exists(free) && !exists(free+".gz") {
+			return free
+		}
+		free = name + "." + strconv.Itoa(i)
+	}
+}
+
+func exists(name string) bool {
+	_, err := os.Lstat(name)
+	return !os.IsNotExist(err)
+}
+
+// rotateDated switches to the dated file of the period of t and points the
+// symlink at it. It runs in the writer goroutine, before the first line of
+// the period is written.
+func (c *core) rotateDated(t time.Time) {
+	if c.fileName == "" {
+		return
+	}
+
+	// 新建一个文件
+	nowLogFile := c.fileName + "." + c.rotateInterval.suffix(t)
+	f, err := createFile(nowLogFile)
+	if err != nil {
+		stderrf("%v", err)
+		return
+	}
+	c.mu.Lock()
+	oldF := c.f
+	if oldF == nil {
+		// detached by SetOutput meanwhile
+		c.mu.Unlock()
+		closeFile(f)
+		return
+	}
+	c.setOut(f)
+	c.f = f
+	c.mu.Unlock()
+
+	closeFile(oldF)
+
+	// 建立连接
+	if err := symlink(filepath.Base(nowLogFile), c.fileName); err != nil {
+		stderrf("%v", err)
+	}
+	c.rotated()
+}
+
+// symlink points link at target. It does nothing if link already points at
+// target, e.g. after a restart on the same day, otherwise link is replaced
+// atomically by renaming a temporary link over it.
+func symlink(target, link string) error {
+	if target == filepath.Base(link) {
+		return fmt.Errorf("xlog: symlink %s points at itself", link)
+	}
+	if cur, err := os.Readlink(link); err == nil && cur == target {
+		return nil
+	}
+
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// openLogFiles counts the files opened by createFile and not yet closed by
+// closeFile, so the tests can tell that every rotation closes the file it
+// leaves exactly once.
+var openLogFiles int64
+
+// createFile opens filePath for appending, creating it and its missing
+// parent directories.
+func createFile(filePath string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&openLogFiles, 1)
+	return f, nil
+}
+
+// closeFile closes a file of createFile.
+func closeFile(f *os.File) error {
+	atomic.AddInt64(&openLogFiles, -1)
+	return f.Close()
+}
@@ -0,0 +1,410 @@
+package xlog
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClock is a clock for Options.Now that only moves when told to.
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.t = t
+	c.mu.Unlock()
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "xlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// waitFile waits for the writer goroutines to create name.
+func waitFile(t *testing.T, name string) {
+	for i := 0; i < 200; i++ {
+		if _, err := os.Stat(name); err == nil {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%s was not created", name)
+}
+
+func TestHourlyRotation(t *testing.T) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 15, 59, 0, 0, time.Local)}
+	logFile := filepath.Join(tempDir(t), "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{RotateInterval: Hourly, Now: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("at 15")
+	waitFile(t, logFile+".2024010115")
+
+	clock.Set(time.Date(2024, 1, 1, 16, 0, 0, 0, time.Local))
+	logger.Info("at 16")
+	waitFile(t, logFile+".2024010116")
+	logger.Close()
+
+	if target, err := os.Readlink(logFile); err != nil || target != "test.log.2024010116" {
+		t.Fatalf("symlink to %q (%v), want test.log.2024010116", target, err)
+	}
+	// the first line of a period goes to its file
+	for suffix, want := range map[string]string{".2024010115": " at 15", ".2024010116": " at 16"} {
+		if lines := readLines(t, logFile+suffix); len(lines) != 1 || !strings.HasSuffix(lines[0], want) {
+			t.Errorf("%s has %q, want a line ending with %q", suffix, lines, want)
+		}
+	}
+}
+
+func TestNestedLogDir(t *testing.T) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 15, 0, 0, 0, time.Local)}
+	logFile := filepath.Join(tempDir(t), "a", "b", "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{Now: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("nested")
+	logger.Close()
+
+	b, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) == 0 {
+		t.Fatal("log file is empty")
+	}
+	if target, err := os.Readlink(logFile); err != nil || target != "test.log.20240101" {
+		t.Fatalf("symlink to %q (%v), want test.log.20240101", target, err)
+	}
+}
+
+func TestSymlinkToItself(t *testing.T) {
+	link := filepath.Join(tempDir(t), "test.log")
+	if err := symlink("test.log", link); err == nil {
+		t.Fatal("symlink to itself succeeded")
+	}
+}
+
+func TestRotateByRename(t *testing.T) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 23, 59, 0, 0, time.Local)}
+	logFile := filepath.Join(tempDir(t), "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{RotateByRename: true, Now: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("day 1")
+	clock.Set(time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local))
+	logger.Info("day 2")
+	logger.Close()
+
+	if fi, err := os.Lstat(logFile); err != nil || fi.Mode()&os.ModeSymlink != 0 {
+		t.Fatalf("%s is not a regular file (%v)", logFile, err)
+	}
+	for name, want := range map[string]string{
+		logFile + ".20240101": " day 1\n",
+		logFile:               " day 2\n",
+	} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); strings.Count(got, "\n") != 1 || !strings.HasSuffix(got, want) {
+			t.Errorf("%s = %q, want a single line ending in %q", filepath.Base(name), got, want)
+		}
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	for _, byRename := range []bool{false, true} {
+		clock := &testClock{t: time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)}
+		logFile := filepath.Join(tempDir(t), "test.log")
+		logger, err := NewLoggerFromFile(logFile, Options{RotateByRename: byRename, Now: clock.Now, MaxSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if logger.maxSize != 1<<20 {
+			t.Fatalf("maxSize = %d, want 1MB", logger.maxSize)
+		}
+		// a few lines per file rather than a megabyte
+		logger.maxSize = 200
+		line := strings.Repeat("x", 60)
+		for i := 0; i < 5; i++ {
+			logger.Info(line)
+		}
+		logger.Close()
+
+		// each file holds the lines written until it reached 200 bytes
+		name := logFile + ".20240101"
+		files := []string{name + ".1", name + ".2", name}
+		if byRename {
+			files = []string{name, name + ".1", logFile}
+		}
+		for i, want := range []int{2, 2, 1} {
+			if got := len(readLines(t, files[i])); got != want {
+				t.Errorf("rename %v: %s has %d lines, want %d", byRename, filepath.Base(files[i]), got, want)
+			}
+		}
+	}
+}
+
+func TestRotateIntervals(t *testing.T) {
+	// a Sunday evening
+	ts := time.Date(2024, 12, 29, 23, 30, 15, 0, time.UTC)
+	tests := []struct {
+		r      RotateInterval
+		suffix string
+		next   time.Time
+	}{
+		{Daily, "20241229", time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC)},
+		{Hourly, "2024122923", time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC)},
+		// ISO week 1 of 2025 starts on Monday 2024-12-30
+		{Weekly, "2024W52", time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC)},
+		{RotateEvery(15 * time.Minute), "20241229233000", time.Date(2024, 12, 29, 23, 45, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := tt.r.suffix(ts); got != tt.suffix {
+			t.Errorf("%d: suffix %q, want %q", tt.r, got, tt.suffix)
+		}
+		next := tt.r.next(ts)
+		if !next.Equal(tt.next) {
+			t.Errorf("%d: next %v, want %v", tt.r, next, tt.next)
+		}
+		if tt.r.period(next) <= tt.r.period(ts) || tt.r.period(next.Add(-time.Second)) != tt.r.period(ts) {
+			t.Errorf("%d: periods do not change at %v", tt.r, next)
+		}
+	}
+	if got := Weekly.suffix(ts.Add(time.Hour)); got != "2025W01" {
+		t.Errorf("Weekly: suffix %q after the turn of the week, want 2025W01", got)
+	}
+}
+
+func TestRotateWhileIdle(t *testing.T) {
+	logFile := filepath.Join(tempDir(t), "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{RotateInterval: RotateEvery(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	first, err := os.Readlink(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is logged, the timer rotates
+	for i := 0; i < 300; i++ {
+		if cur, err := os.Readlink(logFile); err == nil && cur != first {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("%s still points at %s", logFile, first)
+}
+
+// readLines returns the lines of name.
+func readLines(t *testing.T, name string) []string {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestRotateClockJumps(t *testing.T) {
+	// silence the warning of the jump back
+	stderr := os.Stderr
+	os.Stderr, _ = os.Open(os.DevNull)
+	defer func() { os.Stderr = stderr }()
+
+	clock := &testClock{t: time.Date(2024, 1, 1, 15, 59, 59, 0, time.Local)}
+	logFile := filepath.Join(tempDir(t), "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{RotateInterval: Hourly, RotateByRename: true, Now: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the clock oscillates around 16:00, then jumps far ahead and back
+	for _, at := range []time.Time{
+		time.Date(2024, 1, 1, 15, 59, 59, 0, time.Local),
+		time.Date(2024, 1, 1, 16, 0, 1, 0, time.Local),
+		time.Date(2024, 1, 1, 15, 59, 58, 0, time.Local),
+		time.Date(2024, 1, 1, 16, 0, 2, 0, time.Local),
+		time.Date(2050, 1, 1, 0, 0, 0, 0, time.Local),
+		time.Date(2024, 1, 1, 16, 0, 3, 0, time.Local),
+	} {
+		clock.Set(at)
+		logger.Info(at.Format("15:04:05"))
+	}
+	logger.Close()
+
+	files := map[string]int{
+		logFile + ".2024010115": 1,
+		logFile + ".2024010116": 3,
+		logFile + ".2050010100": 1,
+		logFile:                 1,
+	}
+	for name, want := range files {
+		if got := readLines(t, name); len(got) != want {
+			t.Errorf("%s has %q, want %d lines", filepath.Base(name), got, want)
+		}
+	}
+	if names, _ := filepath.Glob(logFile + "*"); len(names) != len(files) {
+		t.Errorf("got files %q", names)
+	}
+}
+
+func TestRotateUTC(t *testing.T) {
+	// 01:30 happens twice on 2024-11-03 in New York, in EDT and then in EST
+	edt := time.Date(2024, 11, 3, 1, 30, 0, 0, time.FixedZone("EDT", -4*3600))
+	est := time.Date(2024, 11, 3, 1, 30, 0, 0, time.FixedZone("EST", -5*3600))
+	clock := &testClock{t: edt}
+	logFile := filepath.Join(tempDir(t), "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{RotateInterval: Hourly, RotateByRename: true, UTC: true, Now: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("first")
+	clock.Set(est)
+	logger.Info("second")
+	logger.Close()
+
+	for name, want := range map[string]string{
+		logFile + ".2024110305": "2024/11/03 05:30:00",
+		logFile:                 "2024/11/03 06:30:00",
+	} {
+		got := readLines(t, name)
+		if len(got) != 1 || !strings.HasPrefix(got[0], want) {
+			t.Errorf("%s has %q, want a line at %s", filepath.Base(name), got, want)
+		}
+	}
+}
+
+// openFiles returns the number of open file descriptors of the process.
+func openFiles(t *testing.T) int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+	return len(fds)
+}
+
+func TestRotateClosesFiles(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"dated":       {},
+		"synchronous": {Synchronous: true},
+		"rename":      {RotateByRename: true},
+		"size":        {MaxSize: 1},
+		"rename size": {RotateByRename: true, MaxSize: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			clock := &testClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+			opts.RotateInterval = Hourly
+			opts.Now = clock.Now
+			before := openFiles(t)
+			logFiles := atomic.LoadInt64(&openLogFiles)
+			logger, err := NewLoggerFromFile(filepath.Join(tempDir(t), "test.log"), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 300; i++ {
+				if opts.MaxSize == 0 {
+					clock.Set(clock.Now().Add(time.Hour))
+				}
+				logger.Info("tick")
+			}
+			logger.Flush()
+			// the log file, plus slack for the runtime
+			if n := openFiles(t); n > before+3 {
+				t.Fatalf("%d files open after 300 rotations, %d before", n, before)
+			}
+			if n := atomic.LoadInt64(&openLogFiles) - logFiles; n != 1 {
+				t.Errorf("%d log files open after 300 rotations, want 1", n)
+			}
+			logger.Close()
+			if n := atomic.LoadInt64(&openLogFiles) - logFiles; n != 0 {
+				t.Errorf("%d log files open after Close", n)
+			}
+		})
+	}
+}
+
+func TestFinalizerClosesLeakedLogger(t *testing.T) {
+	logFile := filepath.Join(tempDir(t), "test.log")
+	before := openFiles(t)
+	func() {
+		// held back by WriteAhead until the logger is closed
+		logger, err := NewLoggerFromFile(logFile, Options{WriteAhead: true, Flag: log.LstdFlags})
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Info("leaked")
+	}()
+
+	for i := 0; i < 100; i++ {
+		runtime.GC()
+		if b, _ := ioutil.ReadFile(logFile); len(b) > 0 && openFiles(t) <= before {
+			if !strings.HasSuffix(string(b), " leaked\n") {
+				t.Errorf("got %q", b)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the leaked logger was not flushed and closed")
+}
+
+func TestReopen(t *testing.T) {
+	logFile := filepath.Join(tempDir(t), "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{RotateByRename: true, WriteAhead: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("before")
+	// as logrotate does
+	if err := os.Rename(logFile, logFile+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("after")
+	logger.Close()
+
+	for name, want := range map[string]string{
+		logFile + ".1": " before\n",
+		logFile:        " after\n",
+	} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); !strings.HasSuffix(got, want) {
+			t.Errorf("%s = %q, want suffix %q", filepath.Base(name), got, want)
+		}
+	}
+
+	if err := logger.Reopen(); err != ErrClosed {
+		t.Errorf("Reopen after Close = %v, want ErrClosed", err)
+	}
+}
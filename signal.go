@@ -0,0 +1,111 @@
+package xlog
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// InstallSignalFlush closes l, writing out its buffer, when the process
+// receives one of sigs, SIGINT and SIGTERM by default, and then raises the
+// signal again for its usual effect, e.g. exiting. Signal handling is
+// global to the process, so only one logger should install it. It stops
+// watching once l is closed.
+func (l *Logger) InstallSignalFlush(sigs ...os.Signal) {
+	if l.nop {
+		return
+	}
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		defer signal.Stop(c)
+		select {
+		case sig := <-c:
+			l.Close()
+			// without c, the signal goes to the other handlers or has its
+			// default effect
+			signal.Stop(c)
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(sig)
+			}
+		case <-l.quit:
+		}
+	}()
+}
+
+// InstallLevelCycleSignal moves the level of l one step each time the
+// process receives sig, Debug to Info to Warn to Error and back to Debug,
+// and logs the new level, e.g. to turn on Debug during an incident:
+//
+//	logger.InstallLevelCycleSignal(syscall.SIGUSR1)
+//	kill -USR1 <pid>
+//
+// SIGUSR1 and SIGUSR2 only exist on Unix. Signal handling is global to the
+// process, so only one logger should install it for a given signal. It
+// stops watching once l is closed.
+func (l *Logger) InstallLevelCycleSignal(sig os.Signal) {
+	if l.nop {
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-c:
+				level := LogLevel(atomic.LoadInt32(&l.level)) + 1
+				if level > LevelError {
+					level = LevelDebug
+				}
+				l.SetLogLevel(level)
+				l.logf(0, LevelWarn, "level set to %v", level)
+			case <-l.quit:
+				return
+			}
+		}
+	}()
+}
+
+// InstallReopenSignal calls Reopen each time the process receives one of
+// sigs, SIGHUP by default, e.g. from the postrotate script of logrotate:
+//
+//	postrotate
+//		kill -HUP $(cat /run/app.pid)
+//	endscript
+//
+// There is no default on js, where it does nothing without sigs. A
+// failure is logged to the file still open. Signal handling is global
+// to the process, so only one logger should install it for a given signal.
+// It stops watching once l is closed.
+func (l *Logger) InstallReopenSignal(sigs ...os.Signal) {
+	if l.nop {
+		return
+	}
+	if len(sigs) == 0 {
+		sigs = reopenSignals
+	}
+	if len(sigs) == 0 {
+		// signal.Notify would relay all the signals
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-c:
+				if err := l.Reopen(); err != nil && err != ErrClosed {
+					l.errorf("reopen: %v", err)
+				}
+			case <-l.quit:
+				return
+			}
+		}
+	}()
+}
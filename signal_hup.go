@@ -0,0 +1,12 @@
+//go:build !js
+// +build !js
+
+package xlog
+
+import (
+	"os"
+	"syscall"
+)
+
+// reopenSignals are the default signals of InstallReopenSignal.
+var reopenSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,10 @@
+//go:build js
+// +build js
+
+package xlog
+
+import "os"
+
+// reopenSignals are the default signals of InstallReopenSignal, there is
+// no SIGHUP on js.
+var reopenSignals []os.Signal
@@ -0,0 +1,84 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package xlog
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInstallSignalFlush(t *testing.T) {
+	// stands in for the default effect of the signal
+	caught := make(chan os.Signal, 2)
+	signal.Notify(caught, syscall.SIGUSR1)
+	defer signal.Stop(caught)
+
+	var buf syncBuffer
+	logger := NewLogger(&buf, Options{WriteAhead: true})
+	logger.InstallSignalFlush(syscall.SIGUSR1)
+	logger.Info("hello")
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+
+	// the signal and then the signal raised again after Close
+	for i := 0; i < 2; i++ {
+		select {
+		case <-caught:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d signals, want 2", i)
+		}
+	}
+	if atomic.LoadInt32(&logger.closed) == 0 {
+		t.Fatal("logger is not closed")
+	}
+	if got := buf.String(); !strings.HasSuffix(got, " hello\n") {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestInstallLevelCycleSignal(t *testing.T) {
+	var buf syncBuffer
+	logger := NewLogger(&buf, Options{Level: LevelWarn})
+	logger.InstallLevelCycleSignal(syscall.SIGUSR2)
+
+	for _, want := range []LogLevel{LevelError, LevelDebug, LevelInfo} {
+		syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+		waitLevel(t, logger, want)
+	}
+	logger.Close()
+
+	if got := buf.String(); strings.Count(got, "xlog: level set to ") != 3 || !strings.HasSuffix(got, " xlog: level set to info\n") {
+		t.Fatalf("got %q", got)
+	}
+	// located at the goroutine watching the signal
+	if got := buf.String(); strings.Count(got, " signal.go:") != 3 {
+		t.Fatalf("got %q, want lines located in signal.go", got)
+	}
+}
+
+func TestInstallReopenSignal(t *testing.T) {
+	logFile := filepath.Join(tempDir(t), "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{RotateByRename: true, ReopenOnSignal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	if err := os.Rename(logFile, logFile+".1"); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+
+	for i := 0; i < 500; i++ {
+		if _, err := os.Stat(logFile); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the file was not reopened")
+}
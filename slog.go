@@ -0,0 +1,113 @@
+//go:build go1.21
+// +build go1.21
+
+package xlog
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+)
+
+// SlogHandler returns a slog.Handler writing to l, so that
+// slog.New(logger.SlogHandler()) logs through l. Levels below Info map to
+// Debug and levels from Error up to Error, attributes become fields with
+// their groups joined by dots, e.g. "req.id". The lines carry the fields of
+// l and of the context as the Ctx methods do, and go to the writers of
+// WithWriter.
+func (l *Logger) SlogHandler() slog.Handler {
+	return &slogHandler{l: l}
+}
+
+type slogHandler struct {
+	l      *Logger
+	fields []Field
+	prefix string // the open groups, e.g. "req."
+}
+
+func slogLevel(level slog.Level) LogLevel {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.l.enabled(slogLevel(level))
+}
+
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	level := slogLevel(r.Level)
+	if !h.l.enabled(level) {
+		return nil
+	}
+
+	lc := LogContent{
+		Time:   r.Time,
+		Level:  level,
+		File:   "???",
+		static: h.l.static,
+		tees:   h.l.tees,
+	}
+	if lc.Time.IsZero() {
+		lc.Time = h.l.now()
+	}
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		lc.File, lc.Line = frame.File, frame.Line
+	}
+	lc.setMessage(r.Message)
+	base := h.l.contextFields()
+	ctxFields := h.l.ctxFields(ctx)
+	lc.Fields = make([]Field, 0, len(base)+len(ctxFields)+len(h.fields)+r.NumAttrs())
+	lc.Fields = append(append(append(lc.Fields, base...), ctxFields...), h.fields...)
+	r.Attrs(func(a slog.Attr) bool {
+		lc.Fields = appendAttr(lc.Fields, h.prefix, a)
+		return true
+	})
+	h.l.enqueue(lc)
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	nh := *h
+	nh.fields = make([]Field, len(h.fields), len(h.fields)+len(attrs))
+	copy(nh.fields, h.fields)
+	for _, a := range attrs {
+		nh.fields = appendAttr(nh.fields, h.prefix, a)
+	}
+	return &nh
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	nh := *h
+	nh.prefix = h.prefix + name + "."
+	return &nh
+}
+
+// appendAttr appends a as fields, flattening groups.
+func appendAttr(fields []Field, prefix string, a slog.Attr) []Field {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			fields = appendAttr(fields, prefix, ga)
+		}
+		return fields
+	}
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	return append(fields, Field{Key: prefix + a.Key, Value: a.Value.Any()})
+}
@@ -0,0 +1,50 @@
+//go:build go1.21
+// +build go1.21
+
+package xlog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSlogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Level: LevelInfo})
+	sl := slog.New(logger.SlogHandler()).With("app", "api").WithGroup("req")
+
+	sl.Debug("filtered")
+	_, file, line, _ := runtime.Caller(0)
+	sl.Warn("slow", "id", 7, slog.Group("db", "ms", 120))
+	logger.Close()
+
+	want := fmt.Sprintf(" [warn] %s:%d slow app=api req.id=7 req.db.ms=120\n", filepath.Base(file), line+1)
+	if got := buf.String(); strings.Contains(got, "filtered") || !strings.HasSuffix(got, want) {
+		t.Fatalf("got %q, want suffix %q", got, want)
+	}
+}
+
+func TestSlogHandlerContext(t *testing.T) {
+	var buf, tee bytes.Buffer
+	logger := NewLogger(&buf, Options{
+		ContextKeys: map[string]interface{}{"request_id": requestIDKey{}},
+	})
+	tl, restore := logger.WithFields(map[string]interface{}{"svc": "api"}).WithWriter(&tee)
+	sl := slog.New(tl.SlogHandler())
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "r-42")
+	sl.InfoContext(ctx, "done", "status", 200)
+	restore()
+	logger.Close()
+
+	want := " done svc=api request_id=r-42 status=200\n"
+	if got := buf.String(); !strings.HasSuffix(got, want) || tee.String() != got {
+		t.Fatalf("got %q and %q in the tee, want suffix %q in both", got, tee.String(), want)
+	}
+}
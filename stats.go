@@ -0,0 +1,86 @@
+package xlog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// dropReportEvery is how often the lines dropped meanwhile are reported by
+// a Warn line per level.
+const dropReportEvery = 10 * time.Second
+
+// Stats is a snapshot of the counters of a logger.
+type Stats struct {
+	// Lines is the number of lines written per level.
+	Lines map[LogLevel]uint64
+	// Dropped is the number of lines that could not be enqueued, e.g. by
+	// Options.Overflow or MaxLinesPerSec, and DroppedLines the same per
+	// level.
+	Dropped      uint64
+	DroppedLines map[LogLevel]uint64
+	// WriteErrors is the number of failed writes to the output.
+	WriteErrors uint64
+	// Buffered and BufferCap are the occupancy and capacity of the buffer.
+	Buffered  int
+	BufferCap int
+}
+
+// counters are updated atomically, it is the first field of core to keep
+// the 64-bit words aligned on 32-bit platforms.
+type counters struct {
+	lines        [LevelFatal + 1]uint64
+	droppedLines [LevelFatal + 1]uint64
+	dropped      uint64
+	writeErrors  uint64
+}
+
+func (c *counters) addLine(level LogLevel) {
+	if level >= 0 && int(level) < len(c.lines) {
+		atomic.AddUint64(&c.lines[level], 1)
+	}
+}
+
+func (c *counters) addDropped(level LogLevel) {
+	atomic.AddUint64(&c.dropped, 1)
+	if level >= 0 && int(level) < len(c.droppedLines) {
+		atomic.AddUint64(&c.droppedLines[level], 1)
+	}
+}
+
+// reportDropped logs how many lines of each level were dropped since the
+// last report, e.g. "dropped 1532 debug lines in the last 10s", so that
+// the loss shows in the output itself. c.mu must be held.
+func (c *core) reportDropped(now time.Time) {
+	since := now.Sub(c.lastDropReport).Round(time.Second)
+	if since == 0 {
+		since = now.Sub(c.lastDropReport).Round(time.Millisecond)
+	}
+	for i := range c.stats.droppedLines {
+		n := atomic.LoadUint64(&c.stats.droppedLines[i])
+		if n == c.droppedReported[i] {
+			continue
+		}
+		c.warnLocked("dropped %d %v lines in the last %v", n-c.droppedReported[i], LogLevel(i), since)
+		c.droppedReported[i] = n
+	}
+	c.lastDropReport = now
+	c.nextDropReport = now.Add(dropReportEvery)
+}
+
+// Stats returns the current counters of the logger. It never blocks the
+// logging path, so it is cheap to call from a metrics exporter.
+func (l *Logger) Stats() Stats {
+	s := Stats{
+		Lines:        make(map[LogLevel]uint64, len(l.stats.lines)),
+		Dropped:      atomic.LoadUint64(&l.stats.dropped),
+		DroppedLines: make(map[LogLevel]uint64, len(l.stats.droppedLines)),
+		WriteErrors:  atomic.LoadUint64(&l.stats.writeErrors),
+		Buffered:     len(l.buffer),
+		BufferCap:    cap(l.buffer),
+	}
+	for i := range l.stats.lines {
+		s.Lines[LogLevel(i)] = atomic.LoadUint64(&l.stats.lines[i])
+		s.DroppedLines[LogLevel(i)] = atomic.LoadUint64(&l.stats.droppedLines[i])
+	}
+	return s
+}
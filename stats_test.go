@@ -0,0 +1,72 @@
+package xlog
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestStats(t *testing.T) {
+	logger := NewLogger(ioutil.Discard, Options{})
+	logger.Info("a")
+	logger.Info("b")
+	logger.Error("c")
+	logger.Close()
+	logger.TryInfo("d")
+
+	s := logger.Stats()
+	if s.Lines[LevelInfo] != 2 || s.Lines[LevelError] != 1 || s.Lines[LevelWarn] != 0 {
+		t.Errorf("Lines = %v", s.Lines)
+	}
+	if s.WriteErrors != 0 {
+		t.Errorf("WriteErrors = %d, want 0", s.WriteErrors)
+	}
+	if s.Dropped != 1 {
+		t.Errorf("Dropped = %d, want 1", s.Dropped)
+	}
+	if s.Buffered != 0 || s.BufferCap != defaultBufferSize {
+		t.Errorf("Buffered = %d/%d, want 0/%d", s.Buffered, s.BufferCap, defaultBufferSize)
+	}
+
+	logger = NewLogger(errWriter{}, Options{})
+	logger.Warn("e")
+	logger.Close()
+
+	s = logger.Stats()
+	if s.WriteErrors != 1 || s.Lines[LevelWarn] != 0 {
+		t.Errorf("WriteErrors = %d, Lines = %v, want 1 write error", s.WriteErrors, s.Lines)
+	}
+}
+
+func TestDroppedReport(t *testing.T) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{MaxLinesPerSec: 1, Synchronous: true, Now: clock.Now})
+	for i := 0; i < 5; i++ {
+		logger.Debug("flood")
+	}
+	logger.TryInfo("flood")
+	clock.Set(clock.Now().Add(dropReportEvery))
+	logger.Error("next")
+	logger.Close()
+
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) != 5 ||
+		!strings.HasSuffix(lines[1], " dropped 4 debug lines in the last 10s") ||
+		!strings.HasSuffix(lines[2], " dropped 1 info lines in the last 10s") ||
+		!strings.HasSuffix(lines[3], " next") {
+		t.Fatalf("got %q", buf.String())
+	}
+	if s := logger.Stats(); s.DroppedLines[LevelDebug] != 4 || s.DroppedLines[LevelInfo] != 1 || s.Dropped != 5 {
+		t.Errorf("DroppedLines = %v, Dropped = %d", s.DroppedLines, s.Dropped)
+	}
+}
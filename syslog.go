@@ -0,0 +1,281 @@
+package xlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Syslog facilities for SyslogFormatter.Facility.
+const (
+	FacilityUser   = 1
+	FacilityDaemon = 3
+	FacilityLocal0 = 16
+	FacilityLocal1 = 17
+	FacilityLocal2 = 18
+	FacilityLocal3 = 19
+	FacilityLocal4 = 20
+	FacilityLocal5 = 21
+	FacilityLocal6 = 22
+	FacilityLocal7 = 23
+)
+
+// SyslogFormatter renders lines as syslog messages, the priority combining
+// Facility, FacilityUser when zero, with the LogLevel.Severity of the line.
+// By default the format is the BSD one of RFC 3164, fields following the
+// message as key=value pairs:
+//
+//	<14>Jan  2 15:04:05 host app[42]: x.go:12 hello k=v
+//
+// With RFC5424, fields, static fields and the correlation ID go to the
+// structured data, under StructuredDataID or "xlog@32473":
+//
+//	<14>1 2006-01-02T15:04:05.000000+08:00 host app 42 - [xlog@32473 k="v"] x.go:12 hello
+//
+// Hostname and AppName default to the host name and the program name.
+// Lines have no trailing newline, DialSyslog frames them for the transport.
+type SyslogFormatter struct {
+	Facility         int
+	RFC5424          bool
+	Hostname         string
+	AppName          string
+	StructuredDataID string
+}
+
+var (
+	syslogHostOnce sync.Once
+	syslogHost     string
+)
+
+func (f SyslogFormatter) Format(lc LogContent) []byte {
+	buf := lc.buffer()
+
+	facility := f.Facility
+	if facility == 0 {
+		facility = FacilityUser
+	}
+	hostname := f.Hostname
+	if hostname == "" {
+		syslogHostOnce.Do(func() {
+			syslogHost, _ = os.Hostname()
+		})
+		hostname = syslogHost
+	}
+	appName := f.AppName
+	if appName == "" {
+		appName = filepath.Base(os.Args[0])
+	}
+
+	buf.WriteByte('<')
+	buf.WriteString(strconv.Itoa(facility*8 + lc.Level.Severity()))
+	buf.WriteByte('>')
+	if f.RFC5424 {
+		buf.WriteString("1 ")
+		buf.WriteString(lc.Time.Format(logfmtTimeLayout))
+		buf.WriteByte(' ')
+		writeSyslogName(buf, hostname, 255)
+		buf.WriteByte(' ')
+		writeSyslogName(buf, appName, 48)
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.Itoa(os.Getpid()))
+		buf.WriteString(" - ")
+		f.writeStructuredData(buf, lc)
+	} else {
+		buf.WriteString(lc.Time.Format(time.Stamp))
+		buf.WriteByte(' ')
+		writeSyslogName(buf, hostname, 255)
+		buf.WriteByte(' ')
+		writeSyslogName(buf, appName, 32)
+		buf.WriteByte('[')
+		buf.WriteString(strconv.Itoa(os.Getpid()))
+		buf.WriteString("]:")
+		if lc.ID != "" {
+			buf.WriteString(" [")
+			buf.WriteString(lc.ID)
+			buf.WriteByte(']')
+		}
+	}
+	if lc.File != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(lc.File)
+		buf.WriteByte(':')
+		buf.WriteString(strconv.Itoa(lc.Line))
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(strings.TrimSuffix(lc.Message(), "\n"))
+	if !f.RFC5424 {
+		writeFields(buf, lc.Fields)
+		buf.WriteString(lc.staticText())
+	}
+
+	return buf.Bytes()
+}
+
+// writeStructuredData writes the SD-ELEMENT of the fields of lc, or "-"
+// when it has none.
+func (f SyslogFormatter) writeStructuredData(buf *bytes.Buffer, lc LogContent) {
+	if lc.ID == "" && len(lc.Fields) == 0 && lc.static == nil {
+		buf.WriteByte('-')
+		return
+	}
+
+	id := f.StructuredDataID
+	if id == "" {
+		id = "xlog@32473"
+	}
+	buf.WriteByte('[')
+	writeSyslogName(buf, id, 32)
+	if lc.ID != "" {
+		writeSyslogParam(buf, "id", lc.ID)
+	}
+	for _, field := range lc.Fields {
+		v := field.value()
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		writeSyslogParam(buf, field.Key, fmt.Sprint(v))
+	}
+	if lc.static != nil {
+		for i, k := range lc.static.keys {
+			writeSyslogParam(buf, k, lc.static.values[i])
+		}
+	}
+	buf.WriteByte(']')
+}
+
+// writeSyslogName writes s cut to max bytes, with the characters RFC 5424
+// does not allow in names, like spaces, '=', ']' and '"', replaced by '_'.
+func writeSyslogName(buf *bytes.Buffer, s string, max int) {
+	if s == "" {
+		buf.WriteByte('-')
+		return
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '=' || c == ']' || c == '"' {
+			c = '_'
+		}
+		buf.WriteByte(c)
+	}
+}
+
+func writeSyslogParam(buf *bytes.Buffer, name, value string) {
+	buf.WriteByte(' ')
+	writeSyslogName(buf, name, 32)
+	buf.WriteString(`="`)
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == '"' || c == '\\' || c == ']' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(value[i])
+	}
+	buf.WriteByte('"')
+}
+
+// syslogSockets are where the local syslog daemon listens, depending on
+// the system.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// DialSyslog connects to the syslog daemon at raddr over network, e.g.
+// "udp" or "tcp", or to the local one when both are empty, for a sink
+// rendered by SyslogFormatter:
+//
+//	w, err := xlog.DialSyslog("udp", "logs.example.com:514")
+//	logger.AddSink(w, xlog.SyslogFormatter{RFC5424: true}, xlog.LevelInfo)
+//
+// Each message is a datagram, or a line over a stream, and a failed write
+// is retried once on a new connection.
+func DialSyslog(network, raddr string) (io.WriteCloser, error) {
+	w := &syslogWriter{network: network, raddr: raddr}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+type syslogWriter struct {
+	network string
+	raddr   string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (w *syslogWriter) connect() error {
+	if w.network != "" || w.raddr != "" {
+		conn, err := net.Dial(w.network, w.raddr)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+		return nil
+	}
+
+	for _, path := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				w.conn = conn
+				return nil
+			}
+		}
+	}
+	return errors.New("xlog: no local syslog daemon")
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	msg := p
+	if w.stream() && !bytes.HasSuffix(p, []byte{'\n'}) {
+		msg = append(msg[:len(msg):len(msg)], '\n')
+	}
+	if w.conn != nil {
+		if _, err := w.conn.Write(msg); err == nil {
+			return len(p), nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	if err := w.connect(); err != nil {
+		return 0, err
+	}
+	if _, err := w.conn.Write(msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// stream reports whether messages need a newline to be told apart.
+func (w *syslogWriter) stream() bool {
+	if w.conn == nil {
+		return strings.HasPrefix(w.network, "tcp")
+	}
+	switch w.conn.LocalAddr().Network() {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return true
+	}
+	return false
+}
+
+func (w *syslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
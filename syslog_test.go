@@ -0,0 +1,76 @@
+package xlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogFormatter(t *testing.T) {
+	lc := LogContent{
+		Time:   time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC),
+		Level:  LevelWarn,
+		File:   "x.go",
+		Line:   12,
+		Args:   []interface{}{"hello world"},
+		Fields: []Field{String("path", `/a]"b`), Int("status", 500)},
+		static: newStaticFields(map[string]string{"app": "api"}),
+	}
+	pid := os.Getpid()
+	tests := []struct {
+		f    SyslogFormatter
+		want string
+	}{
+		{
+			SyslogFormatter{Hostname: "web-1", AppName: "api"},
+			fmt.Sprintf(`<12>Jan  2 03:04:05 web-1 api[%d]: x.go:12 hello world path="/a]\"b" status=500 app=api`, pid),
+		},
+		{
+			SyslogFormatter{Facility: FacilityLocal0, RFC5424: true, Hostname: "web-1", AppName: "my api"},
+			fmt.Sprintf(`<132>1 2020-01-02T03:04:05.000006Z web-1 my_api %d - [xlog@32473 path="/a\]\"b" status="500" app="api"] x.go:12 hello world`, pid),
+		},
+	}
+	for _, tt := range tests {
+		if got := string(tt.f.Format(lc)); got != tt.want {
+			t.Errorf("%+v:\ngot  %q\nwant %q", tt.f, got, tt.want)
+		}
+	}
+
+	lc.Fields, lc.static = nil, nil
+	got := string(SyslogFormatter{RFC5424: true, Hostname: "h", AppName: "a"}.Format(lc))
+	if want := " - - x.go:12 hello world"; !strings.HasSuffix(got, want) {
+		t.Errorf("got %q, want suffix %q", got, want)
+	}
+}
+
+func TestDialSyslog(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+
+	w, err := DialSyslog("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	logger := NewLogger(ioutil.Discard, Options{})
+	logger.AddSink(w, SyslogFormatter{Hostname: "h", AppName: "a"}, LevelInfo)
+	logger.Error("boom")
+	logger.Close()
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b[:n]); !strings.HasPrefix(got, "<11>") || !strings.HasSuffix(got, " boom") {
+		t.Errorf("got %q", got)
+	}
+}
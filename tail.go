@@ -0,0 +1,58 @@
+package xlog
+
+import "strings"
+
+// ring holds the last lines written, oldest first from next.
+type ring struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func newRing(size int) *ring {
+	return &ring{lines: make([]string, size)}
+}
+
+// add copies line, it is called by the writer goroutine with c.mu held.
+func (r *ring) add(line []byte) {
+	r.lines[r.next] = strings.TrimSuffix(string(line), "\n")
+	r.next++
+	if r.next == len(r.lines) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// last returns a copy of the last n lines, oldest first.
+func (r *ring) last(n int) []string {
+	size := r.next
+	if r.full {
+		size = len(r.lines)
+	}
+	if n > size {
+		n = size
+	}
+
+	tail := make([]string, n)
+	start := r.next - n
+	if start < 0 {
+		start += len(r.lines)
+	}
+	for i := range tail {
+		tail[i] = r.lines[(start+i)%len(r.lines)]
+	}
+	return tail
+}
+
+// Tail returns up to the last n lines written, oldest first and without
+// their newline. It returns nil unless the logger was created with
+// Options.RingSize, which bounds n. Lines still in the buffer are not
+// included.
+func (l *Logger) Tail(n int) []string {
+	if l.ring == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ring.last(n)
+}
@@ -0,0 +1,52 @@
+package xlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTail(t *testing.T) {
+	logger := NewLogger(ioutil.Discard, Options{RingSize: 3})
+	defer logger.Close()
+
+	if got := logger.Tail(5); len(got) != 0 {
+		t.Fatalf("Tail of an empty ring = %q", got)
+	}
+	for i := 1; i <= 5; i++ {
+		logger.Infof("line %d", i)
+		logger.Flush()
+
+		// the last min(i, 3) lines
+		var want []string
+		for j := i - 2; j <= i; j++ {
+			if j >= 1 {
+				want = append(want, fmt.Sprintf("line %d", j))
+			}
+		}
+		got := logger.Tail(5)
+		msgs := make([]string, len(got))
+		for k, line := range got {
+			msgs[k] = line[strings.LastIndex(line, "line "):]
+		}
+		if !reflect.DeepEqual(msgs, want) {
+			t.Fatalf("after %d lines: Tail(5) = %q, want %q", i, got, want)
+		}
+	}
+	if got := logger.Tail(1); len(got) != 1 || !strings.HasSuffix(got[0], " line 5") {
+		t.Fatalf("Tail(1) = %q", got)
+	}
+}
+
+func TestTailDisabled(t *testing.T) {
+	logger := NewLogger(ioutil.Discard, Options{})
+	defer logger.Close()
+
+	logger.Info("hello")
+	logger.Flush()
+	if got := logger.Tail(1); got != nil {
+		t.Fatalf("Tail without RingSize = %q", got)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+// +build darwin freebsd netbsd openbsd dragonfly
+
+package xlog
+
+import "syscall"
+
+const ioctlReadTermios = syscall.TIOCGETA
@@ -0,0 +1,33 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows
+// +build linux darwin freebsd netbsd openbsd dragonfly windows
+
+package xlog
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIsTerminalDevNull(t *testing.T) {
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer f.Close()
+	logger := NewLogger(f, Options{})
+	defer logger.Close()
+	if logger.IsTerminal() {
+		t.Errorf("%s is a terminal", os.DevNull)
+	}
+
+	// the master side of a pseudo terminal answers the ioctl
+	pty, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	defer pty.Close()
+	logger.SetOutput(pty)
+	if !logger.IsTerminal() {
+		t.Error("/dev/ptmx is not a terminal")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package xlog
+
+import (
+	"os"
+	"syscall"
+)
+
+// isTerminal reports whether f is a console, NUL is not.
+func isTerminal(f *os.File) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(f.Fd()), &mode) == nil
+}
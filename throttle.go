@@ -0,0 +1,48 @@
+package xlog
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding up to one second of lines, for
+// Options.MaxLinesPerSec.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSec int, now time.Time) *rateLimiter {
+	return &rateLimiter{rate: float64(perSec), tokens: float64(perSec), last: now}
+}
+
+// allow takes a token if one is left at now.
+func (r *rateLimiter) allow(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if elapsed := now.Sub(r.last); elapsed > 0 {
+		r.tokens += elapsed.Seconds() * r.rate
+		if r.tokens > r.rate {
+			r.tokens = r.rate
+		}
+		r.last = now
+	}
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// throttled reports whether lc is dropped by Options.MaxLinesPerSec, and
+// counts it. Error lines and above are never dropped.
+func (c *core) throttled(lc LogContent) bool {
+	if c.limiter == nil || lc.Level >= LevelError || c.limiter.allow(lc.Time) {
+		return false
+	}
+	c.stats.addDropped(lc.Level)
+	lc.recycle()
+	return true
+}
@@ -0,0 +1,31 @@
+package xlog
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestMaxLinesPerSec(t *testing.T) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	logger := NewLogger(ioutil.Discard, Options{MaxLinesPerSec: 10, Now: clock.Now})
+
+	for i := 0; i < 100; i++ {
+		logger.Info("flood")
+	}
+	if err := logger.TryInfo("flood"); err != ErrThrottled {
+		t.Fatalf("TryInfo = %v, want %v", err, ErrThrottled)
+	}
+	logger.Error("critical")
+	clock.Set(clock.Now().Add(500 * time.Millisecond))
+	for i := 0; i < 100; i++ {
+		logger.Info("flood")
+	}
+	logger.Close()
+
+	s := logger.Stats()
+	if s.Lines[LevelInfo] != 15 || s.Lines[LevelError] != 1 || s.Dropped != 186 {
+		t.Fatalf("info %d, error %d, dropped %d, want 15, 1 and 186",
+			s.Lines[LevelInfo], s.Lines[LevelError], s.Dropped)
+	}
+}
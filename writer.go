@@ -0,0 +1,103 @@
+package xlog
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"sync"
+)
+
+// Writer returns a writer logging each line written to it at level, e.g.
+// to capture the stderr of a subprocess at Error and its stdout at Info. A
+// line without its newline yet is kept until the newline arrives or the
+// writer is closed, closing it leaves l open. A line longer than
+// Options.MaxMessageBytes, or 64 KiB without it, is logged in pieces of
+// that size.
+func (l *Logger) Writer(level LogLevel) io.WriteCloser {
+	return &lineWriter{l: l, level: level}
+}
+
+// maxPartialLine caps the partial line kept by a lineWriter when the logger
+// has no MaxMessageBytes.
+const maxPartialLine = 64 << 10
+
+// lineWriter is the writer of Logger.Writer.
+type lineWriter struct {
+	l     *Logger
+	level LogLevel
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(p)
+	max := w.maxPartial()
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		end := i
+		if i < 0 {
+			end = len(p)
+		}
+		if k := max - len(w.partial); end > k {
+			// too long to keep, logged in pieces
+			w.partial = append(w.partial, p[:k]...)
+			w.log(w.partial)
+			w.partial = w.partial[:0]
+			p = p[k:]
+			continue
+		}
+		if i < 0 {
+			w.partial = append(w.partial, p...)
+			break
+		}
+		if len(w.partial) > 0 {
+			w.partial = append(w.partial, p[:i]...)
+			w.log(w.partial)
+			w.partial = w.partial[:0]
+		} else {
+			w.log(p[:i])
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// maxPartial returns the length past which a partial line is logged
+// without waiting for its newline.
+func (w *lineWriter) maxPartial() int {
+	if w.l.maxMessageBytes > 0 {
+		return w.l.maxMessageBytes
+	}
+	return maxPartialLine
+}
+
+// Close logs the partial line left, if any.
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.log(w.partial)
+		w.partial = nil
+	}
+	return nil
+}
+
+func (w *lineWriter) log(line []byte) {
+	w.l.outputw(w.level, string(bytes.TrimSuffix(line, []byte{'\r'})), nil)
+}
+
+// StdLogger returns a standard library logger writing through l at level,
+// for APIs taking a *log.Logger like http.Server.ErrorLog. The lines are
+// located at the calls to the *log.Logger.
+func (l *Logger) StdLogger(level LogLevel) *log.Logger {
+	// skips lineWriter.Write and the Print method with its helper
+	return log.New(&lineWriter{l: l.WithCallerSkip(3), level: level}, "", 0)
+}
+
+// NewStdLogger is l.StdLogger(level).
+func NewStdLogger(l *Logger, level LogLevel) *log.Logger {
+	return l.StdLogger(level)
+}
@@ -0,0 +1,144 @@
+package xlog
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Level: LevelInfo, Flag: log.LstdFlags})
+	w := logger.Writer(LevelError)
+	w.Write([]byte("first\nsec"))
+	w.Write([]byte("ond\r\nthi"))
+	logger.Writer(LevelDebug).Write([]byte("filtered\n"))
+	logger.Flush()
+	if strings.Contains(buf.String(), "thi") {
+		t.Errorf("partial line written before its newline: %q", buf.String())
+	}
+	w.Close()
+	logger.Close()
+
+	want := []string{"[error] first", "[error] second", "[error] thi"}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %q, want %q", lines, want)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %d: got %q, want suffix %q", i, line, want[i])
+		}
+	}
+}
+
+// messageFormatter renders the message of a line only.
+type messageFormatter struct{}
+
+func (messageFormatter) Format(lc LogContent) []byte {
+	return []byte(lc.Message() + "\n")
+}
+
+func TestWriterLongLine(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Formatter: messageFormatter{}, MaxMessageBytes: 4})
+	w := logger.Writer(LevelInfo)
+	w.Write([]byte("abc"))
+	w.Write([]byte("defghij"))
+	w.Write([]byte("klm\nn"))
+	w.Close()
+	logger.Close()
+	if got, want := buf.String(), "abcd\nefgh\nijkl\nm\nn\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	logger = NewLogger(ioutil.Discard, Options{})
+	defer logger.Close()
+	lw := logger.Writer(LevelInfo).(*lineWriter)
+	lw.Write(bytes.Repeat([]byte{'x'}, 3*maxPartialLine+1))
+	if len(lw.partial) != 1 || cap(lw.partial) > maxPartialLine {
+		t.Errorf("kept %d bytes in a buffer of %d, want 1 in at most %d", len(lw.partial), cap(lw.partial), maxPartialLine)
+	}
+}
+
+func TestWriterErrorLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Flag: log.LstdFlags})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	srv.Config.ErrorLog = log.New(logger.Writer(LevelWarn), "", 0)
+	srv.Start()
+	if resp, err := http.Get(srv.URL); err == nil {
+		resp.Body.Close()
+	}
+	srv.Close()
+	logger.Close()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if !strings.Contains(lines[0], "[warn] http: panic serving ") || !strings.HasSuffix(lines[0], ": boom") || len(lines) < 2 {
+		t.Errorf("got %q, want the panic and its stack", lines)
+	}
+}
+
+func TestWriterStderr(t *testing.T) {
+	if os.Getenv("XLOG_TEST_STDERR") != "" {
+		fmt.Fprint(os.Stderr, "first\nsecond\nno newline")
+		return
+	}
+
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Flag: log.LstdFlags})
+	w := logger.Writer(LevelError)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWriterStderr$")
+	cmd.Env = append(os.Environ(), "XLOG_TEST_STDERR=1")
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	logger.Close()
+
+	want := []string{"[error] first", "[error] second", "[error] no newline"}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %q, want %q", lines, want)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %d: got %q, want suffix %q", i, line, want[i])
+		}
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Flag: log.Lshortfile})
+	std := NewStdLogger(logger, LevelWarn)
+	_, _, line, _ := runtime.Caller(0)
+	std.Printf("http: TLS handshake error from %s", "10.0.0.1")
+	std.Println("two")
+	logger.Close()
+
+	want := []string{
+		fmt.Sprintf("[warn] writer_test.go:%d http: TLS handshake error from 10.0.0.1", line+1),
+		fmt.Sprintf("[warn] writer_test.go:%d two", line+2),
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %q, want %q", lines, want)
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], want[i]) {
+			t.Errorf("got %q, want suffix %q", lines[i], want[i])
+		}
+	}
+}
@@ -6,22 +6,35 @@
 // of each logged message.
 // Every log message is output on a separate line: if the message being
 // printed does not end in a newline, the logger will add one.
-// The Fatal functions call os.Exit(1) after writing the log message.
+// The Fatal functions call os.Exit(1), or Options.FatalExitCode, after
+// writing the log message.
 // The Panic functions call panic after writing the log message.
+// Building with the xlog_release tag turns the Debug and Debugf functions
+// into empty stubs, so their calls cost nothing in release builds:
+//
+//	go build -tags xlog_release
 
 package xlog
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -35,7 +48,9 @@ const (
 	levelPanic     = "panic"
 )
 
-//LogLevel log level
+// LogLevel log level. As a threshold, e.g. of SetLogLevel, LevelPanic and
+// LevelFatal act like LevelError: Error lines are always logged when Panic
+// or Fatal ones are, whatever the order of the constants.
 type LogLevel int
 
 const (
@@ -47,258 +62,1751 @@ const (
 	LevelFatal
 )
 
+// String returns the lower-case name of the level, e.g. "info", or
+// "level=N" for a level out of range, so its lines are still
+// self-describing.
+func (level LogLevel) String() string {
+	switch level {
+	case LevelDebug:
+		return levelDebug
+	case LevelInfo:
+		return levelInfo
+	case LevelWarn:
+		return levelWarn
+	case LevelError:
+		return levelError
+	case LevelPanic:
+		return levelPanic
+	case LevelFatal:
+		return levelFatal
+	}
+	return "level=" + strconv.Itoa(int(level))
+}
+
+// ParseLevel returns the level named s, like the names returned by String,
+// ignoring case.
+func ParseLevel(s string) (LogLevel, error) {
+	for level := LevelDebug; level <= LevelFatal; level++ {
+		if strings.EqualFold(s, level.String()) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("xlog: unknown level %q", s)
+}
+
+// passes reports whether a line at level passes the threshold t, see
+// LogLevel.
+func (level LogLevel) passes(t LogLevel) bool {
+	if t > LevelError {
+		t = LevelError
+	}
+	return level >= t
+}
+
+// letter returns the upper-case initial of the level, e.g. 'I' for Info,
+// or '?' for levels out of range.
+func (level LogLevel) letter() byte {
+	if level < LevelDebug || level > LevelFatal {
+		return '?'
+	}
+	return "DIWEPF"[level]
+}
+
+// Severity returns the syslog severity of the level: Fatal is 1 (alert),
+// Panic 2 (crit), Error 3, Warn 4, Info 6 and Debug 7. Lower is more severe.
+// The values are stable, unlike the order of the LogLevel constants, so
+// external tools can rely on them. Levels out of range are clamped to Debug
+// or Fatal.
+func (level LogLevel) Severity() int {
+	switch {
+	case level <= LevelDebug:
+		return 7
+	case level == LevelInfo:
+		return 6
+	case level == LevelWarn:
+		return 4
+	case level == LevelError:
+		return 3
+	case level == LevelPanic:
+		return 2
+	default:
+		return 1
+	}
+}
+
+var (
+	// ErrBufferFull is returned by the Try methods when the buffer has no room.
+	ErrBufferFull = errors.New("xlog: buffer is full")
+	// ErrClosed is returned by the Try methods after the logger has been closed.
+	ErrClosed = errors.New("xlog: logger is closed")
+	// ErrThrottled is returned by the Try methods when the line is dropped
+	// by Options.MaxLinesPerSec.
+	ErrThrottled = errors.New("xlog: too many lines per second")
+)
+
 var (
 	zeroInterface     interface{}
-	defaultXLogger    *Logger
+	defaultXLogger    atomic.Value // *Logger
 	defaultBufferSize = 1024
 )
 
+// OverflowPolicy tells what logging calls do when the buffer is full, see
+// Options.Overflow.
+type OverflowPolicy int
+
+const (
+	// Block waits for the writer goroutine to make room.
+	Block OverflowPolicy = iota
+	// DropNewest drops the line being logged.
+	DropNewest
+	// DropOldest drops the oldest line of the buffer to make room.
+	DropOldest
+)
+
 func init() {
-	defaultXLogger = NewLogger(os.Stdout, Options{})
-	defaultXLogger.calldepth = 3
+	defaultXLogger.Store(NewLogger(os.Stdout, Options{}))
+}
+
+// std returns the logger used by the package level functions.
+func std() *Logger {
+	return defaultXLogger.Load().(*Logger)
+}
+
+// SetDefault replaces the logger used by the package level functions
+// wholesale. The previous default logger is left running, so lines already
+// logged through it are still written.
+func SetDefault(l *Logger) {
+	defaultXLogger.Store(l)
+}
+
+// SetDefaultLevel sets the level of the current default logger, unlike
+// SetDefault it tweaks the existing logger in place.
+func SetDefaultLevel(level LogLevel) {
+	std().SetLogLevel(level)
 }
 
-type logContent struct {
-	t      time.Time
-	level  LogLevel
-	file   string
-	line   int
-	format string
-	v      []interface{}
+// SetDefaultOutput sets the output of the current default logger, unlike
+// SetDefault it tweaks the existing logger in place.
+func SetDefaultOutput(w io.Writer) {
+	std().SetOutput(w)
 }
 
+// Logger is a handle on a core, the state shared with the writer goroutines.
+// The goroutines only reference the core, so a Logger can be garbage
+// collected while they are running.
 type Logger struct {
-	level     LogLevel
-	prefix    string
-	flag      int
+	*core
 	calldepth int
-	out       io.Writer
-	f         *os.File
-	buffer    chan logContent
+	// levelDepth are the extra frames skipped per level, set by
+	// SetLevelCallDepth. It is copied on write, clones share it.
+	levelDepth *[LevelFatal + 1]int
+	// static are the static fields of the lines of this logger, they differ
+	// from those of the core for loggers made by Derive.
+	static *staticFields
+	// tees are the writers added by WithWriter.
+	tees []*tee
+	// fields are added by WithFields to the lines of this logger.
+	fields []Field
 
-	fileName   string
-	dayChange  chan bool
-	curDay     int
-	bufferPool *sync.Pool
+	// root is the logger this one was derived from, it keeps the finalizer
+	// of a file logger from running while derived loggers are in use.
+	root *Logger
 }
 
-// time | level | file | msg
-func (l Logger) format(lc logContent) []byte {
-	buf := l.bufferPool.Get().(*bytes.Buffer)
-	buf.Reset()
-	defer l.bufferPool.Put(buf)
+// clone returns a copy of l sharing its core.
+func (l *Logger) clone() *Logger {
+	nl := *l
+	if nl.root == nil {
+		nl.root = l
+	}
+	return &nl
+}
 
-	year, month, day := lc.t.Date()
-	hour, min, sec := lc.t.Clock()
+type core struct {
+	stats counters
 
-	if day != l.curDay {
-		l.curDay = day
-		l.dayChange <- true
-	}
+	level int32 // LogLevel, accessed atomically
+	// levelMu guards the pending revert of SetLevelFor.
+	levelMu     sync.Mutex
+	levelTimer  *time.Timer
+	levelRevert LogLevel
+	prefix      string
+	flag        int32 // written under mu, read atomically by newContent
 
-	buf.WriteString(strconv.Itoa(year))
-	buf.WriteByte('/')
-	if month < 10 {
-		buf.WriteByte('0')
-	}
-	buf.WriteString(strconv.Itoa(int(month)))
-	buf.WriteByte('/')
-	if day < 10 {
-		buf.WriteByte('0')
+	// mu guards out, bw, f, writers and the options SetOptions replaces
+	mu      sync.Mutex
+	out     io.Writer
+	bw      *bufio.Writer // buffers out with Options.WriteAhead
+	f       *os.File
+	writers []levelWriter
+	// formatters are those of the writers added by AddSink
+	formatters []Formatter
+	ring       *ring        // the last lines for Tail, nil without Options.RingSize
+	limiter    *rateLimiter // nil without Options.MaxLinesPerSec
+
+	buffer    chan LogContent
+	formatter Formatter
+	now       func() time.Time
+
+	maxMessageBytes int
+	monotonic       bool
+	lastTime        time.Time // last time stamped with monotonic
+	static          *staticFields
+	shortLevel      bool
+	separator       string
+
+	goroutines     goroutineContexts
+	contextKeys    []contextKey
+	dumper         func(v interface{}) string
+	appVersion     string
+	fatalExitCode  int
+	stallWarnAfter time.Duration
+	overflow       OverflowPolicy
+	onError        func(err error, line []byte)
+	closeTimeout   time.Duration
+	stallWarned    int32 // 1 once the current stall was reported
+	// the drops of each level already reported, and when, under mu
+	droppedReported [LevelFatal + 1]uint64
+	lastDropReport  time.Time
+	nextDropReport  time.Time
+	dupWarned       int32 // 1 once a duplicate writer was reported
+
+	fileName  string
+	maxSize   int64 // bytes
+	fileSize  int64 // of sizedFile, guarded by mu
+	sizedFile *os.File
+	counter   countingWriter // counts the bytes of a line to fileSize
+
+	maxBackups    int
+	maxAge        time.Duration
+	beforeRemove  func(path string) bool
+	compress      bool
+	compressLevel int
+	sweep         chan struct{} // requests a sweep of the rotated files
+	// writeFailures counts the writes in a row failing on f, fallback is
+	// set while logging to stderr instead, see watchFile.
+	writeFailures  int
+	fallback       bool
+	nextReopen     time.Time
+	reopenEvery    time.Duration
+	nextCheck      time.Time // of the identity of f, see checkFile
+	byRename       bool      // Options.RotateByRename
+	rotateInterval RotateInterval
+	wake           chan struct{} // sent by rotateTimer
+	curPeriod      int
+	curTime        time.Time // the first line of curPeriod
+	bufferPool     *sync.Pool
+
+	closed  int32
+	quit    chan struct{}
+	stopped chan struct{} // closed when the write goroutine returns
+	flush   chan chan error
+	// pause parks the writer goroutine until the channel received is
+	// closed, for the tests inspecting the buffer.
+	pause chan chan struct{}
+	wg    sync.WaitGroup // the write and rotateTimer goroutines
+
+	nop bool // created by NewNopLogger, never logs
+	// caller is the Options.CallerResolver
+	caller func(skip int) (file string, line int, ok bool)
+	// synchronous loggers write in the calling goroutine under syncMu and
+	// start no goroutines.
+	synchronous bool
+	syncMu      sync.Mutex
+}
+
+// advance moves the state of the writer goroutine to the time of lc,
+// rotating the file when lc starts a new period.
+func (c *core) advance(lc *LogContent) {
+	if c.monotonic {
+		// the wall clock may still step back, e.g. on NTP corrections
+		if t := c.now(); t.After(c.lastTime) {
+			c.lastTime = t
+		}
+		lc.Time = c.lastTime
 	}
-	buf.WriteString(strconv.Itoa(day))
-	buf.WriteByte(' ')
-	if hour < 10 {
-		buf.WriteByte('0')
+	p := c.rotateInterval.period(lc.Time)
+	if p == c.curPeriod {
+		return
 	}
-	buf.WriteString(strconv.Itoa(hour))
-	buf.WriteByte(':')
-	if min < 10 {
-		buf.WriteByte('0')
+	if p < c.curPeriod {
+		if c.curTime.Sub(lc.Time) < c.rotateInterval.length() {
+			// the clock stepped back a little, e.g. corrected around
+			// midnight: stay on the current file rather than rotating back
+			// and forth
+			return
+		}
+		fmt.Fprintf(os.Stderr, "xlog: clock went back from %v to %v, rotating back\n", c.curTime, lc.Time)
 	}
-	buf.WriteString(strconv.Itoa(min))
-	buf.WriteByte(':')
-	if sec < 10 {
-		buf.WriteByte('0')
+
+	// in the writer goroutine, so lc goes to the new file
+	if c.byRename {
+		c.renameFile()
+		c.rotated()
+	} else {
+		c.rotateDated(lc.Time)
 	}
-	buf.WriteString(strconv.Itoa(sec))
-	buf.WriteByte(' ')
-	buf.WriteByte('[')
+	c.curPeriod = p
+	c.curTime = lc.Time
+}
 
-	switch lc.level {
-	case LevelDebug:
-		buf.WriteString(levelDebug)
-	case LevelInfo:
-		buf.WriteString(levelInfo)
-	case LevelWarn:
-		buf.WriteString(levelWarn)
-	case LevelError:
-		buf.WriteString(levelError)
-	case LevelPanic:
-		buf.WriteString(levelPanic)
-	case LevelFatal:
-		buf.WriteString(levelFatal)
+// decorate applies the options of the logger to lc before it is formatted.
+// c.mu must be held.
+func (c *core) decorate(lc *LogContent) {
+	flag := int(c.flag)
+	if flag&log.Lshortfile != 0 {
+		lc.File = shortFile(lc.File)
+	}
+	if lc.static == nil {
+		lc.static = c.static
 	}
+	lc.flag = flag
+	lc.shortLevel = c.shortLevel
+	lc.separator = c.separator
+	if c.maxMessageBytes > 0 {
+		lc.setMessage(truncateMessage(lc.Message(), c.maxMessageBytes))
+	}
+}
 
-	buf.WriteByte(']')
-	buf.WriteByte(' ')
-	buf.WriteString(lc.file)
-	buf.WriteByte(':')
-	buf.WriteString(strconv.Itoa(lc.line))
-	buf.WriteByte(' ')
-	if lc.format == "" {
-		buf.WriteString(fmt.Sprint(lc.v...))
-	} else {
-		buf.WriteString(fmt.Sprintf(lc.format, lc.v...))
+// shortFile returns the last element of file, runtime.Caller always uses
+// forward slashes.
+func shortFile(file string) string {
+	if i := strings.LastIndexByte(file, '/'); i >= 0 {
+		return file[i+1:]
 	}
-	buf.WriteByte('\n')
+	return file
+}
 
-	return buf.Bytes()
+// truncateMessage cuts msg to at most max bytes, without splitting a UTF-8
+// sequence, and appends a marker telling how much was cut.
+func truncateMessage(msg string, max int) string {
+	if len(msg) <= max {
+		return msg
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(msg[cut]) {
+		cut--
+	}
+	return msg[:cut] + "...(truncated " + strconv.Itoa(len(msg)-cut) + " bytes)"
 }
 
 type Options struct {
 	Prefix string
 	Level  LogLevel
+	// Flag selects details like log.Lshortfile and log.Llongfile, as for the
+	// standard log package. DefaultLogFlag is used when zero. Without
+	// either file flag lines have no file:line, as with the log package,
+	// where they used to have the long file name.
+	Flag int
+	// CallerResolver finds the caller of the logging call instead of
+	// runtime.Caller, e.g. to skip the frames of generated code. skip is
+	// as for runtime.Caller called in its place, so a resolver calling
+	// runtime.Caller itself passes skip+1.
+	CallerResolver func(skip int) (file string, line int, ok bool)
+	// Formatter renders each line, the formatter of Encoding is used when
+	// nil.
+	Formatter Formatter
+	// Encoding names the formatter used without Formatter: "text" for
+	// TextFormatter, the default, "logfmt" for LogfmtFormatter or "json"
+	// for a JSONFormatter keyed ts, level, caller and msg. An unknown name
+	// is reported, then text is used.
+	Encoding string
+	// Now returns the current time, time.Now is used when nil. Tests can
+	// set it to get deterministic timestamps and rotation.
+	Now func() time.Time
+	// UTC stamps lines in UTC, and rotates and names files after UTC
+	// periods. It is recommended for servers: in local time, the hour
+	// repeated when DST ends falls in a single hourly file.
+	UTC bool
+	// RotateInterval is how often a logger created by NewLoggerFromFile
+	// switches to a new file: Daily by default, Hourly, Weekly or any
+	// RotateEvery. Unless Synchronous, the file is switched at the end of
+	// the period even if nothing is logged.
+	RotateInterval RotateInterval
+	// RotateByRename has a logger created by NewLoggerFromFile always write
+	// to the file it was given, which is renamed after its period on
+	// rotation, e.g. log to log.20240101, and reopened. Unlike the default
+	// dated files and symlink it works the same on every OS.
+	RotateByRename bool
+	// MaxSize, when positive, also rotates the file of NewLoggerFromFile
+	// once it holds more than MaxSize megabytes, see rotateSize.
+	MaxSize int
+	// MaxBackups and MaxAge, when positive, limit the rotated files of
+	// NewLoggerFromFile kept after each rotation to the newest MaxBackups
+	// and to those written within MaxAge, the older ones are removed.
+	MaxBackups int
+	MaxAge     time.Duration
+	// BeforeRemove, when set, is called before removing a rotated file for
+	// MaxBackups or MaxAge, which is kept if it returns false, e.g. until
+	// it has been shipped.
+	BeforeRemove func(path string) bool
+	// Compress has the rotated files of NewLoggerFromFile compressed to
+	// .gz in the background after each rotation, at CompressLevel, e.g.
+	// gzip.BestSpeed, or gzip.DefaultCompression when zero.
+	Compress      bool
+	CompressLevel int
+	// StallWarnAfter, when positive, makes the logger write a warning to
+	// stderr once the buffer has been full for that long, i.e. when the
+	// output is stuck and logging calls block.
+	StallWarnAfter time.Duration
+	// OnError, when set, is called with the error of each failed write and
+	// the line it was writing, nil for a flush of the write-ahead buffer,
+	// e.g. to raise an alert on a full disk. It runs with the logger
+	// locked, so it must not log through it, and line is only valid during
+	// the call.
+	OnError func(err error, line []byte)
+	// BufferSize is the number of lines the buffer of the writer goroutine
+	// holds, 1024 by default.
+	BufferSize int
+	// Overflow is what logging calls do when the buffer is full: they
+	// block by default, or drop a line, counted in Stats, to never wait on
+	// the output. Fatal and Panic lines are never dropped.
+	Overflow OverflowPolicy
+	// StaticFields are appended to every line as key=value pairs, e.g. the
+	// host name or the version of the application.
+	StaticFields map[string]string
+	// ContextKeys maps field names to context keys: the Ctx methods add
+	// the values the context holds for them to the line, e.g.
+	// {"request_id": requestIDKey{}}.
+	ContextKeys map[string]interface{}
+	// WriteAhead buffers the output in memory, it is flushed every second,
+	// after every line at Error level or above and by Close. It trades the
+	// latency of a write per line for the risk of losing the last second of
+	// Debug to Warn lines on a crash.
+	WriteAhead bool
+	// MonotonicOrder stamps lines when they are written rather than when
+	// they are logged, so the times in the output never go backwards even
+	// with concurrent callers. The time then tells when the line was
+	// written, which may be later than the call under load.
+	MonotonicOrder bool
+	// MaxMessageBytes truncates longer messages, a marker telling how many
+	// bytes were cut is appended. Zero means no limit.
+	MaxMessageBytes int
+	// ShortLevel has the text formatter tag lines with the initial of the
+	// level, e.g. "I" rather than "[info]". Other formatters ignore it.
+	ShortLevel bool
+	// RingSize keeps the last RingSize lines in memory for Tail.
+	RingSize int
+	// Outputs receive every line next to the main output, e.g. os.Stdout
+	// for a container next to the file of NewLoggerFromFile, see AddOutput.
+	Outputs []io.Writer
+	// LevelOutputs receive the lines of their level only, e.g. Debug and
+	// Info to app.log and Warn and above to error.log. The main output
+	// still gets every line, it can be ioutil.Discard. See AddLevelWriter.
+	LevelOutputs map[LogLevel]io.Writer
+	// FlushOnSignal closes the logger on SIGINT and SIGTERM before they take
+	// effect, see InstallSignalFlush. Only one logger should set it.
+	FlushOnSignal bool
+	// ReopenOnSignal has NewLoggerFromFile reopen its file on SIGHUP, see
+	// InstallReopenSignal. Only one logger should set it.
+	ReopenOnSignal bool
+	// FieldSeparator separates the time, level, file:line and message of
+	// the text formatter, e.g. "\t" for tab-delimited lines. The default is
+	// a space.
+	FieldSeparator string
+	// MaxLinesPerSec caps the lines logged per second, with bursts of up to
+	// a second worth of lines, to protect a slow output. The excess below
+	// Error level is dropped and counted in Stats. Zero means no limit.
+	MaxLinesPerSec int
+	// Synchronous writes lines in the calling goroutine, in call order, and
+	// starts no goroutines. Callers then wait for the output and for
+	// rotations, and a line is in the output once its call returns, e.g.
+	// for command line tools and tests. With WriteAhead, lines are only
+	// flushed by Error lines, Flush and Close.
+	Synchronous bool
+	// CloseTimeout bounds how long Close and Flush wait for a stuck output,
+	// e.g. a full pipe, also with Synchronous, they then return
+	// context.DeadlineExceeded. Zero means waiting as long as it takes.
+	CloseTimeout time.Duration
+	// Dumper renders the values of Dump, fmt.Sprintf("%+v", v) is used
+	// when nil.
+	Dumper func(v interface{}) string
+	// AppVersion, e.g. a release or a commit, is logged by
+	// LogStartupBanner.
+	AppVersion string
+	// FatalExitCode is the exit status of the Fatal methods, 1 when zero.
+	// FatalCode overrides it for a line.
+	FatalExitCode int
+}
+
+// clock returns the Now of opts, in UTC with opts.UTC.
+func (opts Options) clock() func() time.Time {
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	if opts.UTC {
+		return func() time.Time { return now().UTC() }
+	}
+	return now
 }
 
 // NewLogger is similar to log.New(out io.Writer, prefix string, flag int)
 func NewLogger(out io.Writer, opts Options) *Logger {
-	l := new(Logger)
-	l.prefix = opts.Prefix
-	// l.flag = flag
-	l.level = opts.Level
-	l.out = out
-	l.calldepth = 3
-	l.buffer = make(chan logContent, defaultBufferSize)
-	l.bufferPool = &sync.Pool{
+	c, encodingErr := newCore(out, opts)
+	return c.start(opts, encodingErr)
+}
+
+// newCore builds the core of a logger writing to out, without starting its
+// goroutines, so that NewLoggerFromFile can complete it first. The error is
+// that of an unknown Options.Encoding, to be logged once started.
+func newCore(out io.Writer, opts Options) (*core, error) {
+	c := new(core)
+	c.prefix = opts.Prefix
+	c.flag = int32(opts.Flag)
+	if c.flag == 0 {
+		c.flag = DefaultLogFlag
+	}
+	c.level = int32(opts.Level)
+	c.caller = opts.CallerResolver
+	if c.caller == nil {
+		c.caller = callerOf
+	}
+	c.out = out
+	if opts.WriteAhead {
+		c.bw = bufio.NewWriterSize(out, writeAheadSize)
+	}
+	c.formatter = opts.Formatter
+	var encodingErr error
+	if c.formatter == nil {
+		c.formatter, encodingErr = encodingFormatter(opts.Encoding)
+	}
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	c.buffer = make(chan LogContent, bufferSize)
+	c.bufferPool = &sync.Pool{
 		New: func() interface{} {
 			return new(bytes.Buffer)
 		},
 	}
 
-	l.curDay = time.Now().Day()
-	l.dayChange = make(chan bool)
-	go l.write()
-	go l.changeFileByDay()
+	c.maxMessageBytes = opts.MaxMessageBytes
+	c.monotonic = opts.MonotonicOrder
+	c.shortLevel = opts.ShortLevel
+	c.separator = opts.FieldSeparator
+	if opts.RingSize > 0 {
+		c.ring = newRing(opts.RingSize)
+	}
+	for _, w := range opts.Outputs {
+		c.addWriter(levelWriter{Writer: w, minLevel: LevelDebug})
+	}
+	for _, lw := range levelOutputs(opts.LevelOutputs) {
+		c.addWriter(lw)
+	}
+	c.static = newStaticFields(opts.StaticFields)
+	c.contextKeys = newContextKeys(opts.ContextKeys)
+	c.stallWarnAfter = opts.StallWarnAfter
+	c.overflow = opts.Overflow
+	c.onError = opts.OnError
+	c.closeTimeout = opts.CloseTimeout
+	c.dumper = opts.Dumper
+	c.appVersion = opts.AppVersion
+	c.fatalExitCode = opts.FatalExitCode
+	if c.fatalExitCode == 0 {
+		c.fatalExitCode = 1
+	}
+	c.now = opts.clock()
+	if opts.MaxLinesPerSec > 0 {
+		c.limiter = newRateLimiter(opts.MaxLinesPerSec, c.now())
+	}
+	c.rotateInterval = opts.RotateInterval
+	c.curTime = c.now()
+	c.lastDropReport = c.curTime
+	c.nextDropReport = c.curTime.Add(dropReportEvery)
+	c.curPeriod = c.rotateInterval.period(c.curTime)
+	c.wake = make(chan struct{})
+	c.quit = make(chan struct{})
+	c.stopped = make(chan struct{})
+	c.flush = make(chan chan error)
+	c.pause = make(chan chan struct{})
+	c.synchronous = opts.Synchronous
+	return c, encodingErr
+}
+
+// start starts the writer goroutine of c, unless synchronous, and returns
+// its logger. The fields of c are all set by then.
+func (c *core) start(opts Options, encodingErr error) *Logger {
+	if !c.synchronous {
+		c.wg.Add(1)
+		go c.write()
+	}
+	l := &Logger{core: c, calldepth: 2, static: c.static}
+	if encodingErr != nil {
+		c.errorf("%v", encodingErr)
+	}
+	if opts.FlushOnSignal {
+		l.InstallSignalFlush()
+	}
+	return l
+}
+
+// NewLoggerWithContext is like NewLogger, but the logger is closed when ctx
+// is done: the lines already logged are written out and its goroutines
+// exit, as after Close.
+func NewLoggerWithContext(ctx context.Context, out io.Writer, opts Options) *Logger {
+	l := NewLogger(out, opts)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-l.quit:
+		}
+	}()
 	return l
 }
 
-func NewLoggerFromFile(logFile string, opts Options) *Logger {
-	nowLogFile := logFile + "." + formatTime(time.Now())
-	f, err := createFile(nowLogFile)
-	if err != nil {
-		log.Fatal(err)
+// NewNopLogger returns a logger that discards everything. Unlike a logger
+// writing to ioutil.Discard, it formats nothing and starts no goroutines.
+// Fatal still exits with status 1 and Panic still panics.
+func NewNopLogger() *Logger {
+	c := &core{
+		nop:           true,
+		quit:          make(chan struct{}),
+		now:           time.Now,
+		fatalExitCode: 1,
 	}
+	return &Logger{core: c, calldepth: 2}
+}
 
-	l := NewLogger(f, opts)
-	l.fileName = logFile
-	l.f = f
+func NewLoggerFromFile(logFile string, opts Options) (*Logger, error) {
+	t := opts.clock()()
+	var f *os.File
+	var err error
+	if opts.RotateByRename {
+		f, err = openCurrent(logFile, opts.RotateInterval, t)
+	} else {
+		f, err = openDated(logFile, opts.RotateInterval, t)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	fi, _ := os.Stat(logFile)
-	if fi != nil {
-		os.Remove(logFile)
+	// the file state is set before any goroutine reads it
+	c, encodingErr := newCore(f, opts)
+	c.fileName = logFile
+	c.f = f
+	c.reopenEvery = defaultReopenEvery
+	c.nextCheck = t.Add(c.reopenEvery)
+	c.maxSize = int64(opts.MaxSize) << 20
+	c.maxBackups = opts.MaxBackups
+	c.maxAge = opts.MaxAge
+	c.beforeRemove = opts.BeforeRemove
+	c.compress = opts.Compress
+	c.compressLevel = opts.CompressLevel
+	if c.compressLevel == 0 {
+		c.compressLevel = gzip.DefaultCompression
 	}
-	if err := os.Symlink(filepath.Base(nowLogFile), logFile); err != nil {
-		log.Fatal(err)
+	c.byRename = opts.RotateByRename
+	if (c.maxBackups > 0 || c.maxAge > 0 || c.compress) && !c.synchronous {
+		c.sweep = make(chan struct{}, 1)
+		c.wg.Add(1)
+		go c.sweeper()
+	}
+	if !c.synchronous {
+		c.wg.Add(1)
+		go c.rotateTimer()
+	}
+	l := c.start(opts, encodingErr)
+	if opts.ReopenOnSignal {
+		l.InstallReopenSignal()
 	}
 
-	return l
+	// Best effort: flush and close the file if the logger is dropped
+	// without calling Close. Close remains the preferred way, there is no
+	// hook at a normal program exit.
+	runtime.SetFinalizer(l, (*Logger).Close)
+	return l, nil
 }
 
 func (l *Logger) AddCalldepth(num int) {
 	l.calldepth += num
 }
 
-func (l *Logger) changeFileByDay() {
+// Derive returns a logger sharing the buffer, output and goroutines of l,
+// so that short-lived loggers, e.g. one per request, cost no goroutines.
+// Its lines carry the StaticFields of l overridden by those of opts, the
+// other options belong to the shared output and are ignored.
+func (l *Logger) Derive(opts Options) *Logger {
+	nl := l.clone()
+	if len(opts.StaticFields) > 0 {
+		fields := make(map[string]string, len(opts.StaticFields))
+		if l.static != nil {
+			for i, k := range l.static.keys {
+				fields[k] = l.static.values[i]
+			}
+		}
+		for k, v := range opts.StaticFields {
+			fields[k] = v
+		}
+		nl.static = newStaticFields(fields)
+	}
+	return nl
+}
+
+// tee is a writer added by WithWriter.
+type tee struct {
+	w        io.Writer
+	restored bool // guarded by core.mu
+}
+
+// WithWriter returns a logger sharing the buffer and output of l whose lines
+// are also written to w, e.g. to capture the logs of a batch job, until
+// restore is called. restore returns once the lines logged before it are
+// written, w is never written to after it. l itself is left untouched.
+func (l *Logger) WithWriter(w io.Writer) (logger *Logger, restore func()) {
+	t := &tee{w: w}
+	nl := l.clone()
+	nl.tees = append(l.tees[:len(l.tees):len(l.tees)], t)
+	return nl, func() {
+		l.Flush()
+		l.mu.Lock()
+		t.restored = true
+		l.mu.Unlock()
+	}
+}
+
+// SetLevelCallDepth makes lines at level skip depth more frames than the
+// other levels when reporting the caller, for wrappers of a single level,
+// e.g. an Error wrapper which raises alerts. Like AddCalldepth it must be
+// called before l is in use.
+func (l *Logger) SetLevelCallDepth(level LogLevel, depth int) {
+	if level < LevelDebug || level > LevelFatal {
+		return
+	}
+	levelDepth := new([LevelFatal + 1]int)
+	if l.levelDepth != nil {
+		*levelDepth = *l.levelDepth
+	}
+	levelDepth[level] = depth
+	l.levelDepth = levelDepth
+}
+
+// WithCallerSkip returns a logger sharing the buffer and output of l that
+// skips skip more frames when reporting the caller. Unlike AddCalldepth it
+// leaves l untouched, so wrappers can use it on a shared logger.
+func (l *Logger) WithCallerSkip(skip int) *Logger {
+	nl := l.clone()
+	nl.calldepth += skip
+	return nl
+}
+
+const (
+	writeAheadSize     = 32 * 1024
+	writeAheadInterval = time.Second
+)
+
+func (c *core) write() {
+	defer c.wg.Done()
+	defer close(c.stopped)
+
+	var tick <-chan time.Time
+	if c.bw != nil {
+		ticker := time.NewTicker(writeAheadInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	report := time.NewTicker(dropReportEvery)
+	defer report.Stop()
+
 	for {
 		select {
-		case ok := <-l.dayChange:
-			if ok && l.f != nil {
-
-				// 新建一个文件
-				nowLogFile := l.fileName + "." + formatTime(time.Now())
-				f, err := createFile(nowLogFile)
-				if err != nil {
-					l.Error(err)
-					continue
-				}
-				oldF := l.f
-				l.out = f
-				l.f = f
+		case lc := <-c.buffer:
+			c.writeContent(lc)
+		case <-tick:
+			c.mu.Lock()
+			c.flushAhead()
+			c.mu.Unlock()
+		case <-report.C:
+			c.mu.Lock()
+			c.reportDropped(c.now())
+			c.mu.Unlock()
+		case <-c.wake:
+			// rotates if the period is over
+			lc := LogContent{Time: c.now()}
+			c.advance(&lc)
+		case done := <-c.flush:
+			c.drain()
+			c.mu.Lock()
+			done <- c.flushAhead()
+			c.mu.Unlock()
+		case resume := <-c.pause:
+			<-resume
+		case <-c.quit:
+			// drain what was enqueued before Close
+			c.drain()
+			c.mu.Lock()
+			c.reportDropped(c.now())
+			c.mu.Unlock()
+			return
+		}
+	}
+}
 
-				if oldF != nil {
-					oldF.Close()
-				}
+// drain writes the lines waiting in the buffer.
+func (c *core) drain() {
+	for {
+		select {
+		case lc := <-c.buffer:
+			c.writeContent(lc)
+		default:
+			return
+		}
+	}
+}
 
-				fi, _ := os.Stat(l.fileName)
-				if fi != nil {
-					os.Remove(l.fileName)
-				}
-				// 建立连接
-				if err := os.Symlink(filepath.Base(nowLogFile), l.fileName); err != nil {
-					log.Fatal(err)
-				}
+func (c *core) writeContent(lc LogContent) {
+	c.advance(&lc)
+	buf := c.bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	lc.buf = buf
+
+	c.mu.Lock()
+	if !lc.Time.Before(c.nextDropReport) {
+		// also covers Synchronous, which has no writer goroutine
+		c.reportDropped(lc.Time)
+	}
+	c.checkFile(lc.Time)
+	c.decorate(&lc)
+	err := c.writeOut(lc)
+	c.watchFile(lc, err)
+	full := c.maxSize > 0 && c.f != nil && c.fileSize >= c.maxSize
+	c.mu.Unlock()
+	if full {
+		c.rotateSize()
+		c.rotated()
+	}
+	if err != nil {
+		atomic.AddUint64(&c.stats.writeErrors, 1)
+	} else {
+		c.stats.addLine(lc.Level)
+	}
+	c.bufferPool.Put(buf)
+
+	// Fatal and Panic lines still need their message in terminate
+	if lc.written == nil {
+		lc.recycle()
+	}
+
+	// the buffer drained, report the next stall again
+	if atomic.LoadInt32(&c.stallWarned) == 1 && len(c.buffer) == 0 {
+		atomic.StoreInt32(&c.stallWarned, 0)
+	}
+
+	if lc.written != nil {
+		close(lc.written)
+	}
+}
+
+// writeOut writes lc to the outputs, c.mu must be held. An Encoder writes
+// to the main output directly, the extra writers get the formatted bytes,
+// rendered at most once.
+func (c *core) writeOut(lc LogContent) error {
+	dst := c.out
+	if c.bw != nil {
+		dst = c.bw
+	}
+
+	counting := c.maxSize > 0 && c.f != nil
+	if counting {
+		if c.f != c.sizedFile {
+			// opened or rotated since the last line
+			c.sizedFile = c.f
+			c.fileSize = 0
+			if fi, err := c.f.Stat(); err == nil {
+				c.fileSize = fi.Size()
 			}
 		}
+		c.counter = countingWriter{w: dst}
+		dst = &c.counter
+	}
+
+	var logBytes []byte
+	var err error
+	if enc, ok := c.formatter.(Encoder); ok {
+		err = enc.Encode(lc, dst)
+	} else {
+		logBytes = c.formatter.Format(lc)
+		_, err = dst.Write(logBytes)
+	}
+	if err != nil && c.onError != nil {
+		if logBytes == nil {
+			logBytes = c.formatter.Format(lc)
+		}
+		c.onError(err, logBytes)
+	}
+	if counting {
+		c.fileSize += c.counter.n
+	}
+	if lc.Level >= LevelError {
+		c.flushAhead()
+	}
+
+	for _, w := range c.writers {
+		if w.formatter != 0 || !w.wants(lc.Level) {
+			continue
+		}
+		if logBytes == nil {
+			logBytes = c.formatter.Format(lc)
+		}
+		if _, err := w.Write(logBytes); err != nil {
+			c.writeFailed(err, logBytes)
+		}
+	}
+
+	for _, t := range lc.tees {
+		if t.restored {
+			continue
+		}
+		if logBytes == nil {
+			logBytes = c.formatter.Format(lc)
+		}
+		if _, err := t.w.Write(logBytes); err != nil {
+			c.writeFailed(err, logBytes)
+		}
+	}
+
+	if c.ring != nil {
+		if logBytes == nil {
+			logBytes = c.formatter.Format(lc)
+		}
+		c.ring.add(logBytes)
+	}
+
+	// the sinks with their own formatter, a formatter at a time as they
+	// share the buffer of lc
+	for i, f := range c.formatters {
+		var b []byte
+		for _, w := range c.writers {
+			if w.formatter != i+1 || !w.wants(lc.Level) {
+				continue
+			}
+			if b == nil {
+				b = f.Format(lc)
+			}
+			if _, err := w.Write(b); err != nil {
+				c.writeFailed(err, b)
+			}
+		}
+	}
+	return err
+}
+
+// flushAhead writes out what the write-ahead buffer holds, c.mu must be
+// held.
+func (c *core) flushAhead() error {
+	if c.bw != nil && c.bw.Buffered() > 0 {
+		if err := c.bw.Flush(); err != nil {
+			c.writeFailed(err, nil)
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFailed counts a failed write to an extra output or of the write-ahead
+// buffer and reports it to Options.OnError. c.mu must be held.
+func (c *core) writeFailed(err error, line []byte) {
+	atomic.AddUint64(&c.stats.writeErrors, 1)
+	if c.onError != nil {
+		c.onError(err, line)
+	}
+}
+
+// setOut switches the output, c.mu must be held. The write-ahead buffer is
+// flushed to the previous output first.
+func (c *core) setOut(w io.Writer) {
+	if c.bw != nil {
+		c.flushAhead()
+		c.bw.Reset(w)
+	}
+	c.out = w
+}
+
+// errorf logs an error of the logger itself, e.g. a failed rotation,
+// located at the caller of the function calling errorf.
+func (c *core) errorf(format string, v ...interface{}) {
+	c.logf(1, LevelError, format, v...)
+}
+
+// logf logs a line of the logger itself, located skip frames above the
+// function calling logf: 0 for that function, e.g. a goroutine, 1 for its
+// caller. The message starts with "xlog: " once, errors of the package
+// carrying it already.
+func (c *core) logf(skip int, level LogLevel, format string, v ...interface{}) {
+	if c.synchronous {
+		// the caller may be in writeSync already
+		stderrf(format, v...)
+		return
+	}
+	msg := xlogMessage(format, v...)
+	_, file, line, _ := runtime.Caller(skip + 1)
+	lc := LogContent{
+		Time:  c.now(),
+		Level: level,
+		File:  file,
+		Line:  line,
+	}
+	lc.setMessage(msg)
+	select {
+	case c.buffer <- lc:
+	case <-c.quit:
+	}
+}
+
+// stderrf writes a line of the logger itself to stderr, where logf could
+// block, e.g. on a full buffer in the writer goroutine.
+func stderrf(format string, v ...interface{}) {
+	fmt.Fprintln(os.Stderr, xlogMessage(format, v...))
+}
+
+func xlogMessage(format string, v ...interface{}) string {
+	msg := fmt.Sprintf(format, v...)
+	if !strings.HasPrefix(msg, "xlog: ") {
+		msg = "xlog: " + msg
+	}
+	return msg
+}
+
+// writeClosed is used once the writer goroutine is gone: the line goes
+// straight to stderr so that logging after Close neither blocks nor panics
+// on the buffer.
+func (c *core) writeClosed(lc LogContent) {
+	c.mu.Lock()
+	c.decorate(&lc)
+	line := c.formatter.Format(lc)
+	c.mu.Unlock()
+	os.Stderr.Write(line)
+	terminate(lc)
+}
+
+// terminate exits or panics after a Fatal or Panic line has been written,
+// in the goroutine which logged it so that a Panic can be recovered.
+func terminate(lc LogContent) {
+	if lc.Level == LevelFatal {
+		os.Exit(lc.exitCode)
+	} else if lc.Level == LevelPanic {
+		panic(lc.Message())
+	}
+}
+
+func (l *Logger) output(level LogLevel, format string, v ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	l.enqueue(l.newContent(level, format, v))
+}
+
+// outputCode is like output at fatal level, exiting with code.
+func (l *Logger) outputCode(code int, v ...interface{}) {
+	if !l.enabled(LevelFatal) {
+		return
+	}
+
+	lc := l.newContent(LevelFatal, "", v)
+	lc.exitCode = code
+	l.enqueue(lc)
+}
+
+// outputID is like output but tags the line with a correlation ID.
+func (l *Logger) outputID(level LogLevel, id, format string, v ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	lc := l.newContent(level, format, v)
+	lc.ID = id
+	l.enqueue(lc)
+}
+
+func (c *core) enqueue(lc LogContent) {
+	if c.nop {
+		terminate(lc)
+		return
+	}
+	if c.throttled(lc) {
+		return
+	}
+	if c.synchronous {
+		c.writeSync(lc)
+		return
+	}
+	if atomic.LoadInt32(&c.closed) == 1 || !c.send(lc) {
+		c.writeClosed(lc)
+		return
+	}
+
+	if lc.written != nil {
+		// Fatal and Panic wait for their line, and everything before it,
+		// to be written and flushed.
+		select {
+		case <-lc.written:
+		case <-c.stopped:
+		}
+		terminate(lc)
 	}
 }
 
-func formatTime(t time.Time) string {
-	return fmt.Sprintf("%04d%02d%02d", t.Year(), t.Month(), t.Day())
+// writeSync writes lc in the calling goroutine, for Options.Synchronous.
+func (c *core) writeSync(lc LogContent) {
+	c.syncMu.Lock()
+	if atomic.LoadInt32(&c.closed) == 1 {
+		c.syncMu.Unlock()
+		c.writeClosed(lc)
+		return
+	}
+	c.writeContent(lc)
+	c.syncMu.Unlock()
+	if lc.written != nil {
+		terminate(lc)
+	}
 }
 
-func createFile(filePath string) (*os.File, error) {
-	return os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_RDWR, os.ModePerm)
+// send enqueues lc, it reports false if the logger was closed meanwhile.
+func (c *core) send(lc LogContent) bool {
+	if c.overflow != Block && lc.written == nil {
+		return c.sendOrDrop(lc)
+	}
+	if c.stallWarnAfter > 0 {
+		select {
+		case c.buffer <- lc:
+			return true
+		default:
+			return c.waitStalled(lc)
+		}
+	}
+
+	select {
+	case c.buffer <- lc:
+		return true
+	case <-c.quit:
+		return false
+	}
 }
 
-func (l *Logger) write() {
+// sendOrDrop enqueues lc, dropping it or the oldest line when the buffer is
+// full as Options.Overflow tells.
+func (c *core) sendOrDrop(lc LogContent) bool {
 	for {
 		select {
-		case lc := <-l.buffer:
-			logBytes := l.format(lc)
-			l.out.Write(logBytes)
-			if lc.level == LevelFatal {
-				os.Exit(1)
-			} else if lc.level == LevelPanic {
-				var s string
-				if lc.format == "" {
-					s = fmt.Sprint(lc.v...)
-				} else {
-					s = fmt.Sprintf(lc.format, lc.v...)
+		case c.buffer <- lc:
+			return true
+		case <-c.quit:
+			return false
+		default:
+		}
+
+		if c.overflow == DropNewest {
+			c.stats.addDropped(lc.Level)
+			lc.recycle()
+			return true
+		}
+		select {
+		case old := <-c.buffer:
+			if old.written != nil {
+				// a Fatal or Panic line waits for this one, it goes back
+				// to the end of the buffer rather than being lost
+				if !c.send(old) {
+					return false
 				}
-				panic(s)
+				continue
 			}
+			c.stats.addDropped(old.Level)
+			old.recycle()
+		default:
+			// the writer goroutine emptied the buffer meanwhile
 		}
 	}
 }
 
-func (l *Logger) output(level LogLevel, format string, v ...interface{}) {
-	if level < l.level {
+// waitStalled enqueues lc into a full buffer, reporting the stall on stderr
+// once if it lasts longer than stallWarnAfter.
+func (c *core) waitStalled(lc LogContent) bool {
+	timer := time.NewTimer(c.stallWarnAfter)
+	defer timer.Stop()
+
+	for {
+		select {
+		case c.buffer <- lc:
+			return true
+		case <-c.quit:
+			return false
+		case <-timer.C:
+			if atomic.CompareAndSwapInt32(&c.stallWarned, 0, 1) {
+				fmt.Fprintf(os.Stderr, "xlog: writer stalled, buffer full for %v\n", c.stallWarnAfter)
+			}
+		}
+	}
+}
+
+// tryOutput is like output but never blocks: it reports ErrClosed or
+// ErrBufferFull instead of waiting for the writer goroutine.
+func (l *Logger) tryOutput(level LogLevel, format string, v ...interface{}) error {
+	if !l.enabled(level) {
+		return nil
+	}
+	if atomic.LoadInt32(&l.closed) == 1 {
+		l.stats.addDropped(level)
+		return ErrClosed
+	}
+
+	lc := l.newContent(level, format, v)
+	if l.throttled(lc) {
+		return ErrThrottled
+	}
+	if l.synchronous {
+		l.writeSync(lc)
+		return nil
+	}
+	select {
+	case l.buffer <- lc:
+		return nil
+	default:
+		l.stats.addDropped(level)
+		return ErrBufferFull
+	}
+}
+
+func (c *core) enabled(level LogLevel) bool {
+	if c.nop {
+		// a nop logger logs nothing but still exits and panics, see enqueue
+		return level == LevelPanic || level == LevelFatal
+	}
+	return level.passes(LogLevel(atomic.LoadInt32(&c.level)))
+}
+
+// Render returns the line l would write for level, format and v, with the
+// current time and caller, without logging it: it neither enqueues nor
+// rotates, whatever the level of l. A nop logger renders nothing.
+func (l *Logger) Render(level LogLevel, format string, v ...interface{}) []byte {
+	return l.render(level, format, v...)
+}
+
+func (l *Logger) render(level LogLevel, format string, v ...interface{}) []byte {
+	if l.nop {
+		return nil
+	}
+	lc := l.newContent(level, format, v)
+	buf := l.bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	lc.buf = buf
+
+	l.mu.Lock()
+	l.decorate(&lc)
+	line := append([]byte(nil), l.formatter.Format(lc)...)
+	l.mu.Unlock()
+	l.bufferPool.Put(buf)
+	lc.recycle()
+	return line
+}
+
+// newContent captures the time and the caller, it must be called directly
+// by output, outputID, outputCode, outputCtx, outputw, outputFields,
+// outputDump, tryOutput or render to keep calldepth correct.
+func (l *Logger) newContent(level LogLevel, format string, v []interface{}) LogContent {
+	t := l.now()
+	depth := l.calldepth
+	if l.levelDepth != nil && level >= LevelDebug && level <= LevelFatal {
+		depth += l.levelDepth[level]
+	}
+	var file string
+	var line int
+	if atomic.LoadInt32(&l.flag)&(log.Lshortfile|log.Llongfile) != 0 {
+		var ok bool
+		file, line, ok = l.caller(depth + 1)
+		if !ok {
+			file = "???"
+			line = 0
+		}
+	}
+	// v is copied: the writer goroutine must see the values as they were
+	// even if the caller reuses its slice, and v does not escape, so calls
+	// at a disabled level don't allocate the variadic slice.
+	args := getArgs(v)
+	lc := LogContent{
+		Time:   t,
+		Level:  level,
+		File:   file,
+		Line:   line,
+		Format: format,
+		Args:   *args,
+		args:   args,
+		Fields: l.contextFields(),
+		static: l.static,
+		tees:   l.tees,
+	}
+	if level == LevelFatal || level == LevelPanic {
+		lc.written = make(chan struct{})
+		lc.exitCode = l.fatalExitCode
+	}
+	return lc
+}
+
+// callerOf is runtime.Caller without the program counter, the default
+// Options.CallerResolver.
+func callerOf(skip int) (string, int, bool) {
+	_, file, line, ok := runtime.Caller(skip + 1)
+	return file, line, ok
+}
+
+// argsPool recycles the copies of the arguments made by newContent.
+var argsPool = sync.Pool{
+	New: func() interface{} {
+		s := make([]interface{}, 0, 8)
+		return &s
+	},
+}
+
+// maxPooledArgs keeps unusually long argument lists out of the pool.
+const maxPooledArgs = 64
+
+func getArgs(v []interface{}) *[]interface{} {
+	args := argsPool.Get().(*[]interface{})
+	*args = append((*args)[:0], v...)
+	return args
+}
+
+// recycle returns the pooled slices of lc once it is written.
+func (lc LogContent) recycle() {
+	putArgs(lc.args)
+	putFields(lc.fields)
+}
+
+func putArgs(args *[]interface{}) {
+	if args == nil || cap(*args) > maxPooledArgs {
 		return
 	}
+	s := *args
+	for i := range s {
+		s[i] = nil
+	}
+	*args = s[:0]
+	argsPool.Put(args)
+}
+
+// Close stops the logger after writing everything already enqueued and
+// closes the log file, if any, once committed to stable storage like Sync
+// does. Lines logged after Close are written to stderr. With
+// Options.CloseTimeout, Close gives up on an output stuck for longer and
+// returns context.DeadlineExceeded, the remaining lines are lost and the
+// file is left open.
+func (l *Logger) Close() error {
+	timeout, stop := l.closeTimer()
+	defer stop()
+	// waits for a synchronous write in progress
+	locked := lockBefore(&l.syncMu, timeout)
+	if locked {
+		defer l.syncMu.Unlock()
+	}
+	if !atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
+		return ErrClosed
+	}
+	close(l.quit)
+	if !locked {
+		return context.DeadlineExceeded
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-timeout:
+		return context.DeadlineExceeded
+	}
 
-	t := time.Now()
-	_, file, line, ok := runtime.Caller(l.calldepth)
-	if !ok {
-		file = "???"
-		line = 0
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.flushAhead()
+	if l.f != nil {
+		if serr := l.f.Sync(); err == nil {
+			err = serr
+		}
+		if cerr := closeFile(l.f); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Flush writes out the lines logged so far, including those held back by
+// Options.WriteAhead, and returns the error of the write-ahead flush. It
+// does nothing after Close, which flushes everything itself, and is
+// bounded by Options.CloseTimeout like Close.
+func (l *Logger) Flush() error {
+	if l.nop {
+		return nil
+	}
+	timeout, stop := l.closeTimer()
+	defer stop()
+	if l.synchronous {
+		if !lockBefore(&l.mu, timeout) {
+			return context.DeadlineExceeded
+		}
+		defer l.mu.Unlock()
+		return l.flushAhead()
+	}
+	done := make(chan error, 1)
+	select {
+	case l.flush <- done:
+	case <-l.stopped:
+		return nil
+	case <-timeout:
+		return context.DeadlineExceeded
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+		return context.DeadlineExceeded
+	}
+}
+
+// closeTimer returns a channel receiving after Options.CloseTimeout, or
+// never without a timeout, and the function to stop it.
+func (c *core) closeTimer() (<-chan time.Time, func() bool) {
+	if c.closeTimeout <= 0 {
+		return nil, func() bool { return false }
+	}
+	t := time.NewTimer(c.closeTimeout)
+	return t.C, t.Stop
+}
+
+// lockBefore locks m unless timeout fires first, it reports whether m is
+// locked. A lock obtained too late is released right away.
+func lockBefore(m sync.Locker, timeout <-chan time.Time) bool {
+	if timeout == nil {
+		m.Lock()
+		return true
+	}
+	locked := make(chan struct{})
+	abandoned := make(chan struct{})
+	go func() {
+		m.Lock()
+		select {
+		case locked <- struct{}{}:
+		case <-abandoned:
+			m.Unlock()
+		}
+	}()
+	select {
+	case <-locked:
+		return true
+	case <-timeout:
+		close(abandoned)
+		return false
+	}
+}
+
+// Sync flushes like Flush and then commits the output to stable storage
+// when it is a file, so lines survive a crash of the machine. Other writers
+// are only flushed. Sync matches the interface of loggers like zap, for
+// a deferred call at program start.
+func (l *Logger) Sync() error {
+	if err := l.Flush(); err != nil {
+		return err
 	}
-	l.buffer <- logContent{
-		t:      t,
-		level:  level,
-		file:   file,
-		line:   line,
-		format: format,
-		v:      v,
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.out.(*os.File)
+	if !ok || atomic.LoadInt32(&l.closed) == 1 {
+		return nil
+	}
+	// terminals and pipes, e.g. os.Stderr, cannot be synced
+	if err := f.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return err
 	}
+	return nil
+}
+
+// Enabled reports whether lines at level are logged, so that callers can
+// skip building expensive arguments:
+//
+//	if logger.Enabled(xlog.LevelDebug) {
+//		logger.Debug(dump(state))
+//	}
+func (l *Logger) Enabled(level LogLevel) bool {
+	return !l.nop && l.enabled(level)
 }
 
+// EnabledLevels returns the levels enabled now as a bitmap, bit n set for
+// LogLevel(n), for hot loops which check it once rather than calling
+// Enabled each time:
+//
+//	enabled := logger.EnabledLevels()
+//	for _, p := range packets {
+//		if enabled&(1<<xlog.LevelDebug) != 0 {
+//			logger.Debug(p)
+//		}
+//	}
+//
+// Later changes of the level show in the next snapshot.
+func (l *Logger) EnabledLevels() uint8 {
+	var bits uint8
+	if l.nop {
+		return 0
+	}
+	for level := LevelDebug; level <= LevelFatal; level++ {
+		if l.enabled(level) {
+			bits |= 1 << uint(level)
+		}
+	}
+	return bits
+}
+
+type levelWriter struct {
+	io.Writer
+	minLevel LogLevel
+	// formatter is 0 for the formatter of the logger, or i+1 for
+	// core.formatters[i].
+	formatter int
+	// levels, when not 0, has bit 1<<level set for each level the writer
+	// gets, in place of minLevel.
+	levels uint8
+}
+
+// wants reports whether w gets the lines at level.
+func (w levelWriter) wants(level LogLevel) bool {
+	if w.levels != 0 {
+		return level >= LevelDebug && level <= LevelFatal && w.levels&(1<<uint(level)) != 0
+	}
+	return level.passes(w.minLevel)
+}
+
+// levelOutputs groups the levels of Options.LevelOutputs by writer, as
+// addWriter takes a writer once.
+func levelOutputs(outputs map[LogLevel]io.Writer) []levelWriter {
+	var lws []levelWriter
+	for level := LevelDebug; level <= LevelFatal; level++ {
+		w, ok := outputs[level]
+		if !ok {
+			continue
+		}
+		i := 0
+		for i < len(lws) && !sameWriter(lws[i].Writer, w) {
+			i++
+		}
+		if i == len(lws) {
+			lws = append(lws, levelWriter{Writer: w})
+		}
+		lws[i].levels |= 1 << uint(level)
+	}
+	return lws
+}
+
+// AddWriter adds an output receiving the lines at minLevel or above. The
+// level of the logger still filters lines first, so the logger's level must
+// be at most minLevel for w to see everything it asks for.
+func (l *Logger) AddWriter(w io.Writer, minLevel LogLevel) {
+	l.addWriter(levelWriter{Writer: w, minLevel: minLevel})
+}
+
+// AddLevelWriter adds an output receiving the lines at the given levels
+// only, unlike AddWriter which takes a threshold, e.g.
+//
+//	logger.AddLevelWriter(appLog, xlog.LevelDebug, xlog.LevelInfo)
+func (l *Logger) AddLevelWriter(w io.Writer, levels ...LogLevel) {
+	lw := levelWriter{Writer: w}
+	for _, level := range levels {
+		if level >= LevelDebug && level <= LevelFatal {
+			lw.levels |= 1 << uint(level)
+		}
+	}
+	if lw.levels == 0 {
+		return
+	}
+	l.addWriter(lw)
+}
+
+// AddOutput adds an output receiving every line, like AddWriter at
+// LevelDebug.
+func (l *Logger) AddOutput(w io.Writer) {
+	l.addWriter(levelWriter{Writer: w, minLevel: LevelDebug})
+}
+
+// AddSink is like AddWriter, but the lines written to w are rendered by f,
+// e.g. JSON to a file next to text on stdout. A line is rendered once per
+// distinct formatter, however many sinks share it. A nil f is the
+// formatter of the logger.
+func (l *Logger) AddSink(w io.Writer, f Formatter, minLevel LogLevel) {
+	l.mu.Lock()
+	index := l.formatterIndex(f)
+	l.mu.Unlock()
+	l.addWriter(levelWriter{Writer: w, minLevel: minLevel, formatter: index})
+}
+
+// addWriter adds lw unless its writer is already an output of the logger,
+// which would double its lines, e.g. text and JSON both to stdout. The
+// first duplicate is reported by a warning.
+func (c *core) addWriter(lw levelWriter) {
+	c.mu.Lock()
+	dup := sameWriter(c.out, lw.Writer)
+	for _, w := range c.writers {
+		dup = dup || sameWriter(w.Writer, lw.Writer)
+	}
+	if !dup {
+		c.writers = append(c.writers, lw)
+	}
+	c.mu.Unlock()
+
+	if dup && atomic.CompareAndSwapInt32(&c.dupWarned, 0, 1) {
+		// located at the caller of AddOutput and the like
+		c.logf(2, LevelWarn, "ignoring a writer added twice, %T", lw.Writer)
+	}
+}
+
+// sameWriter reports whether a and b write to the same place: the same
+// value or the same regular file.
+func sameWriter(a, b io.Writer) (same bool) {
+	fa, okA := a.(*os.File)
+	fb, okB := b.(*os.File)
+	if okA && okB {
+		if fa == fb {
+			return true
+		}
+		// Fd would put the files in blocking mode
+		sa, errA := fa.Stat()
+		sb, errB := fb.Stat()
+		return errA == nil && errB == nil && sa.Mode().IsRegular() && os.SameFile(sa, sb)
+	}
+	ta := reflect.TypeOf(a)
+	if ta == nil || ta != reflect.TypeOf(b) || !ta.Comparable() {
+		return false
+	}
+	// a comparable type may still hold values which are not, e.g. a struct
+	// with an interface field holding a map
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+// formatterIndex returns the levelWriter.formatter for f, adding it to
+// c.formatters when new. Formatters of types which are not comparable,
+// like EncoderFunc, are never shared. c.mu must be held.
+func (c *core) formatterIndex(f Formatter) int {
+	if f == nil {
+		return 0
+	}
+	if reflect.TypeOf(f).Comparable() {
+		if f == c.formatter {
+			return 0
+		}
+		for i, g := range c.formatters {
+			if f == g {
+				return i + 1
+			}
+		}
+	}
+	c.formatters = append(c.formatters, f)
+	return len(c.formatters)
+}
+
+// SetLogLevel sets the level, cancelling a pending revert of SetLevelFor.
 func (l *Logger) SetLogLevel(level LogLevel) {
-	l.level = level
+	l.levelMu.Lock()
+	defer l.levelMu.Unlock()
+	if l.levelTimer != nil {
+		l.levelTimer.Stop()
+		l.levelTimer = nil
+	}
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+// SetLevelFor sets the level for d, then reverts it, e.g. to log at Debug
+// for a few minutes while troubleshooting. A call while a revert is pending
+// replaces the level and restarts the timer, the revert still restores the
+// level from before the first call.
+func (l *Logger) SetLevelFor(level LogLevel, d time.Duration) {
+	l.levelMu.Lock()
+	defer l.levelMu.Unlock()
+	if l.levelTimer != nil {
+		l.levelTimer.Stop()
+	} else {
+		l.levelRevert = LogLevel(atomic.LoadInt32(&l.level))
+	}
+	atomic.StoreInt32(&l.level, int32(level))
+
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		l.levelMu.Lock()
+		defer l.levelMu.Unlock()
+		// a later call or SetLogLevel took over
+		if l.levelTimer != t {
+			return
+		}
+		l.levelTimer = nil
+		atomic.StoreInt32(&l.level, int32(l.levelRevert))
+	})
+	l.levelTimer = t
+}
+
+// SetOptions replaces the Prefix, Level, Flag, Formatter or Encoding,
+// ShortLevel and FieldSeparator of the logger at once, e.g. on a config
+// reload. The other options are fixed at creation and ignored, SetOutput
+// changes the output. The level and the caller of a line are taken when it
+// is logged, the rest applies to every line written from then on,
+// including the lines still buffered.
+func (l *Logger) SetOptions(opts Options) {
+	if l.nop {
+		return
+	}
+	flag := opts.Flag
+	if flag == 0 {
+		flag = DefaultLogFlag
+	}
+	formatter := opts.Formatter
+	if formatter == nil {
+		var err error
+		formatter, err = encodingFormatter(opts.Encoding)
+		if err != nil {
+			l.errorf("%v", err)
+		}
+	}
+
+	l.mu.Lock()
+	l.prefix = opts.Prefix
+	atomic.StoreInt32(&l.flag, int32(flag))
+	l.formatter = formatter
+	l.shortLevel = opts.ShortLevel
+	l.separator = opts.FieldSeparator
+	l.mu.Unlock()
+	l.SetLogLevel(opts.Level)
+}
+
+// IsTerminal reports whether the output is a terminal, e.g. to decide on
+// colors. Writers other than an *os.File are never terminals.
+func (l *Logger) IsTerminal() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// under mu, so SetOutput does not close f meanwhile
+	f, ok := l.out.(*os.File)
+	return ok && isTerminal(f)
+}
+
+// SetOutput sets the output destination of the logger. If the logger writes
+// to a file opened by NewLoggerFromFile, that file is closed and daily
+// rotation stops.
+func (l *Logger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setOut(w)
+	l.fallback = false
+	if l.f != nil {
+		closeFile(l.f)
+		l.f = nil
+	}
 }
 
 func (l *Logger) Debug(v ...interface{}) {
-	l.Debugf("", v...)
+	l.output(LevelDebug, "", v...)
 }
 
 func (l *Logger) Debugf(format string, v ...interface{}) {
@@ -306,81 +1814,194 @@ func (l *Logger) Debugf(format string, v ...interface{}) {
 }
 
 func (l *Logger) Warn(v ...interface{}) {
-	l.Warnf("", v...)
+	l.output(LevelWarn, "", v...)
 }
 
 func (l *Logger) Warnf(format string, v ...interface{}) {
 	l.output(LevelWarn, format, v...)
 }
 
-func (l Logger) Info(v ...interface{}) {
-	l.Infof("", v...)
+// TryDebug is like Debug but returns an error instead of blocking when the
+// line can not be enqueued.
+func (l *Logger) TryDebug(v ...interface{}) error {
+	return l.tryOutput(LevelDebug, "", v...)
+}
+
+func (l *Logger) TryDebugf(format string, v ...interface{}) error {
+	return l.tryOutput(LevelDebug, format, v...)
+}
+
+// TryWarn is like Warn but returns an error instead of blocking when the
+// line can not be enqueued.
+func (l *Logger) TryWarn(v ...interface{}) error {
+	return l.tryOutput(LevelWarn, "", v...)
+}
+
+func (l *Logger) TryWarnf(format string, v ...interface{}) error {
+	return l.tryOutput(LevelWarn, format, v...)
+}
+
+func (l *Logger) Info(v ...interface{}) {
+	l.output(LevelInfo, "", v...)
 }
 
-func (l Logger) Infof(format string, v ...interface{}) {
+func (l *Logger) Infof(format string, v ...interface{}) {
 	l.output(LevelInfo, format, v...)
 }
 
-func (l Logger) Error(v ...interface{}) {
-	l.Errorf("", v...)
+// TryInfo is like Info but returns an error instead of blocking when the
+// line can not be enqueued.
+func (l *Logger) TryInfo(v ...interface{}) error {
+	return l.tryOutput(LevelInfo, "", v...)
+}
+
+func (l *Logger) TryInfof(format string, v ...interface{}) error {
+	return l.tryOutput(LevelInfo, format, v...)
+}
+
+func (l *Logger) Error(v ...interface{}) {
+	l.output(LevelError, "", v...)
 }
 
-func (l Logger) Errorf(format string, v ...interface{}) {
+func (l *Logger) Errorf(format string, v ...interface{}) {
 	l.output(LevelError, format, v...)
 }
 
-func (l Logger) Fatal(v ...interface{}) {
-	l.Fatalf("", v...)
+// TryError is like Error but returns an error instead of blocking when the
+// line can not be enqueued.
+func (l *Logger) TryError(v ...interface{}) error {
+	return l.tryOutput(LevelError, "", v...)
 }
 
-func (l Logger) Fatalf(format string, v ...interface{}) {
+func (l *Logger) TryErrorf(format string, v ...interface{}) error {
+	return l.tryOutput(LevelError, format, v...)
+}
+
+// Fatal logs like Info at fatal level, then calls os.Exit with
+// Options.FatalExitCode once the line and every line before it have been
+// written.
+func (l *Logger) Fatal(v ...interface{}) {
+	l.output(LevelFatal, "", v...)
+}
+
+func (l *Logger) Fatalf(format string, v ...interface{}) {
 	l.output(LevelFatal, format, v...)
 }
 
-func (l Logger) Panic(v ...interface{}) {
-	l.Panicf("", v...)
+// FatalCode is like Fatal but exits with code, e.g. to tell a supervisor
+// which kind of failure stopped the program.
+func (l *Logger) FatalCode(code int, v ...interface{}) {
+	l.outputCode(code, v...)
 }
 
-func (l Logger) Panicf(format string, v ...interface{}) {
+// Panic logs like Info at panic level, then panics with the message once
+// the line and every line before it have been written. The panic happens
+// in the calling goroutine, so it can be recovered.
+func (l *Logger) Panic(v ...interface{}) {
+	l.output(LevelPanic, "", v...)
+}
+
+func (l *Logger) Panicf(format string, v ...interface{}) {
 	l.output(LevelPanic, format, v...)
 }
 
-func Debug(v ...interface{}) {
-	defaultXLogger.Debug(v...)
+// Log logs at level, for callers which pick the level at run time. Lines
+// at levels out of range are written with their number, see
+// LogLevel.String, and only LevelFatal and LevelPanic terminate.
+func (l *Logger) Log(level LogLevel, v ...interface{}) {
+	l.output(level, "", v...)
 }
 
-func Debugf(format string, v ...interface{}) {
-	defaultXLogger.Debugf(format, v...)
+func (l *Logger) Logf(level LogLevel, format string, v ...interface{}) {
+	l.output(level, format, v...)
+}
+
+// DebugID and its peers log like Debug, Info, ... with a correlation ID,
+// rendered as "[id]" after the level.
+func (l *Logger) DebugID(id string, v ...interface{}) {
+	l.outputID(LevelDebug, id, "", v...)
+}
+
+func (l *Logger) DebugIDf(id string, format string, v ...interface{}) {
+	l.outputID(LevelDebug, id, format, v...)
+}
+
+func (l *Logger) InfoID(id string, v ...interface{}) {
+	l.outputID(LevelInfo, id, "", v...)
+}
+
+func (l *Logger) InfoIDf(id string, format string, v ...interface{}) {
+	l.outputID(LevelInfo, id, format, v...)
+}
+
+func (l *Logger) WarnID(id string, v ...interface{}) {
+	l.outputID(LevelWarn, id, "", v...)
+}
+
+func (l *Logger) WarnIDf(id string, format string, v ...interface{}) {
+	l.outputID(LevelWarn, id, format, v...)
+}
+
+func (l *Logger) ErrorID(id string, v ...interface{}) {
+	l.outputID(LevelError, id, "", v...)
+}
+
+func (l *Logger) ErrorIDf(id string, format string, v ...interface{}) {
+	l.outputID(LevelError, id, format, v...)
+}
+
+func (l *Logger) FatalID(id string, v ...interface{}) {
+	l.outputID(LevelFatal, id, "", v...)
+}
+
+func (l *Logger) FatalIDf(id string, format string, v ...interface{}) {
+	l.outputID(LevelFatal, id, format, v...)
+}
+
+func (l *Logger) PanicID(id string, v ...interface{}) {
+	l.outputID(LevelPanic, id, "", v...)
+}
+
+func (l *Logger) PanicIDf(id string, format string, v ...interface{}) {
+	l.outputID(LevelPanic, id, format, v...)
 }
 
 func Warn(v ...interface{}) {
-	defaultXLogger.Warn(v...)
+	std().output(LevelWarn, "", v...)
 }
 
 func Warnf(format string, v ...interface{}) {
-	defaultXLogger.Warnf(format, v...)
+	std().output(LevelWarn, format, v...)
+}
+
+func Info(v ...interface{}) {
+	std().output(LevelInfo, "", v...)
+}
+
+func Infof(format string, v ...interface{}) {
+	std().output(LevelInfo, format, v...)
 }
 
 func Error(v ...interface{}) {
-	defaultXLogger.Error(v...)
+	std().output(LevelError, "", v...)
 }
 
 func Errorf(format string, v ...interface{}) {
-	defaultXLogger.Errorf(format, v...)
+	std().output(LevelError, format, v...)
 }
 
 func Fatal(v ...interface{}) {
-	defaultXLogger.Fatal(v...)
+	std().output(LevelFatal, "", v...)
 }
 
 func Fatalf(format string, v ...interface{}) {
-	defaultXLogger.Fatalf(format, v...)
+	std().output(LevelFatal, format, v...)
 }
 
 func Panic(v ...interface{}) {
-	defaultXLogger.Panic(v...)
+	std().output(LevelPanic, "", v...)
 }
 
 func Panicf(format string, v ...interface{}) {
-	defaultXLogger.Panicf(format, v...)
+	std().output(LevelPanic, format, v...)
 }
@@ -1,14 +1,1376 @@
 package xlog
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"go.uber.org/goleak"
 )
 
+// pauseForTest parks the writer goroutine of l between two lines, until
+// resume is called.
+func (l *Logger) pauseForTest() (resume func()) {
+	ch := make(chan struct{})
+	l.pause <- ch
+	return func() { close(ch) }
+}
+
+// pendingForTest returns the lines waiting in the buffer of l, in order,
+// leaving them there. The writer goroutine must be parked by pauseForTest
+// and nothing else logging meanwhile, so that taking the lines out and
+// putting them back neither blocks nor reorders them.
+func (l *Logger) pendingForTest() []LogContent {
+	lines := make([]LogContent, len(l.buffer))
+	for i := range lines {
+		lines[i] = <-l.buffer
+	}
+	for _, lc := range lines {
+		l.buffer <- lc
+	}
+	return lines
+}
+
 func TestA(t *testing.T) {
-	logger := NewLogger(os.Stdout, Options{})
-	// logger := NewLoggerFromFile("log", "", 1)
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{})
+	resume := logger.pauseForTest()
+	logger.Info("hello world")
+	logger.Info("second")
+	logger.Warn("third")
+
+	want := []string{"hello world", "second", "third"}
+	for i := 0; i < 2; i++ {
+		// and again, the snapshot leaves the buffer as it was
+		pending := logger.pendingForTest()
+		if len(pending) != len(want) {
+			t.Fatalf("%d lines pending, want %d", len(pending), len(want))
+		}
+		for j, lc := range pending {
+			if lc.Message() != want[j] {
+				t.Errorf("pending line %d = %q, want %q", j, lc.Message(), want[j])
+			}
+		}
+	}
+	if n := logger.Stats().Buffered; n != 3 {
+		t.Errorf("%d lines buffered, want 3", n)
+	}
+
+	resume()
+	logger.Close()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("wrote %q, want %q", lines, want)
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], " "+want[i]) {
+			t.Errorf("line %d = %q, want suffix %q", i, lines[i], want[i])
+		}
+	}
+	if s := logger.Stats(); s.Lines[LevelInfo] != 2 || s.Lines[LevelWarn] != 1 {
+		t.Errorf("wrote %v, want 2 Info and 1 Warn lines", s.Lines)
+	}
+}
+
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestTryInfo(t *testing.T) {
+	w := blockingWriter{release: make(chan struct{})}
+	logger := NewLogger(w, Options{})
+
+	var err error
+	for i := 0; i <= defaultBufferSize+1 && err == nil; i++ {
+		err = logger.TryInfo("hello world")
+	}
+	if err != ErrBufferFull {
+		t.Fatalf("TryInfo on a full buffer: got %v, want %v", err, ErrBufferFull)
+	}
+
+	close(w.release)
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.TryInfo("hello world"); err != ErrClosed {
+		t.Fatalf("TryInfo after Close: got %v, want %v", err, ErrClosed)
+	}
+}
+
+// gateWriter writes to buf once release is closed.
+type gateWriter struct {
+	release chan struct{}
+	buf     *syncBuffer
+}
+
+func (w gateWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.buf.Write(p)
+}
+
+func TestOverflow(t *testing.T) {
+	for policy, want := range map[OverflowPolicy][]string{
+		DropNewest: {"stuck", "1", "2"},
+		DropOldest: {"stuck", "3", "4"},
+	} {
+		w := gateWriter{release: make(chan struct{}), buf: new(syncBuffer)}
+		logger := NewLogger(w, Options{BufferSize: 2, Overflow: policy})
+		// once the writer took the first line it is stuck in Write
+		logger.Info("stuck")
+		for len(logger.buffer) > 0 {
+			runtime.Gosched()
+		}
+		for _, msg := range []string{"1", "2", "3", "4"} {
+			logger.Info(msg)
+		}
+		close(w.release)
+		logger.Close()
+
+		lines := strings.Split(strings.TrimSuffix(w.buf.String(), "\n"), "\n")
+		// the drops are reported on Close
+		if last := lines[len(lines)-1]; !strings.Contains(last, " [warn] ") || !strings.Contains(last, " dropped 2 info lines in the last ") {
+			t.Errorf("policy %d: got last line %q", policy, last)
+		}
+		var got []string
+		for _, line := range lines[:len(lines)-1] {
+			got = append(got, line[strings.LastIndex(line, " ")+1:])
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("policy %d: got lines %q, want %q", policy, got, want)
+		}
+		if d := logger.Stats().Dropped; d != 2 {
+			t.Errorf("policy %d: Dropped = %d, want 2", policy, d)
+		}
+	}
+}
+
+func TestOnError(t *testing.T) {
+	var errs []string
+	logger := NewLogger(errWriter{}, Options{
+		Synchronous: true,
+		OnError: func(err error, line []byte) {
+			errs = append(errs, err.Error()+": "+string(line))
+		},
+	})
+	logger.Warn("lost")
+	logger.Close()
+
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "write failed: ") || !strings.HasSuffix(errs[0], " lost\n") {
+		t.Fatalf("OnError got %q", errs)
+	}
+}
+
+func TestLogAfterClose(t *testing.T) {
+	stderr := os.Stderr
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = devNull
+	defer func() {
+		os.Stderr = stderr
+		devNull.Close()
+	}()
+
+	logger := NewLogger(ioutil.Discard, Options{})
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Debug("debug")
+	logger.Debugf("%s", "debug")
+	logger.Info("info")
+	logger.Infof("%s", "info")
+	logger.Warn("warn")
+	logger.Warnf("%s", "warn")
+	logger.Error("error")
+	logger.Errorf("%s", "error")
+	logger.TryDebug("debug")
+	logger.TryInfo("info")
+	logger.TryWarn("warn")
+	logger.TryError("error")
+
+	// Panic keeps panicking with its own message, not a channel error.
+	defer func() {
+		if r := recover(); r != "panic" {
+			t.Fatalf("Panic after Close: recovered %v, want %q", r, "panic")
+		}
+	}()
+	logger.Panic("panic")
+}
+
+func TestSetDefault(t *testing.T) {
+	old := std()
+	defer SetDefault(old)
+
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{})
+	SetDefault(logger)
+	SetDefaultLevel(LevelWarn)
+	Info("quiet")
+	Warn("loud")
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.String()
+	if strings.Contains(got, "quiet") || !strings.Contains(got, "[warn] ") || !strings.Contains(got, "xlog_test.go:") {
+		t.Fatalf("default logger output = %q", got)
+	}
+}
+
+func TestCallerLocation(t *testing.T) {
+	old := std()
+	defer SetDefault(old)
+
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{})
+	SetDefault(logger)
+
+	var want []string
+	// mark records the location of the line following its call.
+	mark := func() {
+		_, file, line, _ := runtime.Caller(1)
+		want = append(want, fmt.Sprintf(" %s:%d ", filepath.Base(file), line+1))
+	}
+	mark()
+	logger.Debug("x")
+	mark()
+	logger.Debugf("x")
+	mark()
+	logger.Info("x")
+	mark()
+	logger.Infof("x")
+	mark()
+	logger.Warn("x")
+	mark()
+	logger.Warnf("x")
+	mark()
+	logger.Error("x")
+	mark()
+	logger.Errorf("x")
+	mark()
+	logger.TryInfo("x")
+	mark()
+	logger.TryInfof("x")
+	// Debug is compiled out with xlog_release, see TestDebug
+	mark()
+	Warn("x")
+	mark()
+	Infof("x")
+	mark()
+	Error("x")
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, want[i]) {
+			t.Errorf("line %d = %q, want caller %q", i, line, want[i])
+		}
+	}
+}
+
+func TestDisabledLevelAllocs(t *testing.T) {
+	logger := NewLogger(ioutil.Discard, Options{Level: LevelInfo})
+	defer logger.Close()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		logger.Debugf("hello %s %d", "world", 42)
+	})
+	if allocs != 0 {
+		t.Fatalf("Debugf at a disabled level: %v allocs, want 0", allocs)
+	}
+	if logger.Enabled(LevelDebug) || !logger.Enabled(LevelInfo) {
+		t.Fatal("Enabled does not follow the configured level")
+	}
+}
+
+func BenchmarkDisabledLevel(b *testing.B) {
+	logger := NewLogger(ioutil.Discard, Options{Level: LevelInfo})
+	defer logger.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		logger.Debugf("hello %s %d", "world", 42)
+	}
+}
+
+func TestNowOption(t *testing.T) {
+	now := func() time.Time {
+		return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	}
+
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Now: now})
+	_, file, line, _ := runtime.Caller(0)
 	logger.Info("hello world")
-	time.Sleep(1 * time.Second)
+	logger.Close()
+
+	want := fmt.Sprintf("2020/01/02 03:04:05.000000 [info] %s:%d hello world\n", filepath.Base(file), line+1)
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	dir, err := ioutil.TempDir("", "xlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "test.log")
+	fileLogger, err := NewLoggerFromFile(logFile, Options{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileLogger.Close()
+	if target, err := os.Readlink(logFile); err != nil || target != "test.log.20200102" {
+		t.Fatalf("symlink to %q (%v), want test.log.20200102", target, err)
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{})
+	logger.InfoIDf("req-1", "hello %s", "world")
+	logger.Close()
+
+	if got := buf.String(); !strings.Contains(got, " [info] [req-1] ") || !strings.HasSuffix(got, " hello world\n") {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestNopLogger(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	logger := NewNopLogger()
+	if n := runtime.NumGoroutine(); n != goroutines {
+		t.Fatalf("NewNopLogger started %d goroutines", n-goroutines)
+	}
+
+	logger.SetLogLevel(LevelDebug)
+	allocs := testing.AllocsPerRun(100, func() {
+		logger.Errorf("hello %s", "world")
+		logger.InfoID("req-1", "hello world")
+	})
+	if allocs != 0 {
+		t.Fatalf("nop logger: %v allocs, want 0", allocs)
+	}
+	if err := logger.TryInfo("hello world"); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestNopLoggerTerminates runs itself in a subprocess which exits through
+// the Fatal of a nop logger.
+func TestNopLoggerTerminates(t *testing.T) {
+	if os.Getenv("XLOG_TEST_NOP_FATAL") != "" {
+		NewNopLogger().Fatal("bye")
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestNopLoggerTerminates$")
+	cmd.Env = append(os.Environ(), "XLOG_TEST_NOP_FATAL=1")
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Errorf("got %v, want exit status 1", err)
+	}
+	if len(out) != 0 {
+		t.Errorf("got output %q", out)
+	}
+
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("recovered %v, want boom", r)
+		}
+	}()
+	NewNopLogger().Panicf("b%s", "oom")
+}
+
+func BenchmarkNopLogger(b *testing.B) {
+	logger := NewNopLogger()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		logger.Infof("hello %s", "world")
+	}
+}
+
+func TestNewLoggerFromFileRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "test.log")
+	for _, msg := range []string{"before restart", "after restart"} {
+		logger, err := NewLoggerFromFile(logFile, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Info(msg)
+		if err := logger.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); !strings.Contains(got, "before restart") || !strings.Contains(got, "after restart") {
+		t.Fatalf("log file = %q, want both lines", got)
+	}
+}
+
+func TestMaxMessageBytes(t *testing.T) {
+	tests := []struct {
+		max  int
+		msg  string
+		want string
+	}{
+		{0, "hello world", "hello world"},
+		{11, "hello world", "hello world"},
+		{5, "hello world", "hello...(truncated 6 bytes)"},
+		// "é" is two bytes, the cut moves back to the rune start
+		{2, "héllo", "h...(truncated 5 bytes)"},
+		{3, "héllo", "hé...(truncated 3 bytes)"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logger := NewLogger(&buf, Options{MaxMessageBytes: tt.max})
+		logger.Info(tt.msg)
+		logger.Close()
+		if got := buf.String(); !strings.HasSuffix(got, " "+tt.want+"\n") {
+			t.Errorf("MaxMessageBytes %d, %q: got %q, want suffix %q", tt.max, tt.msg, got, tt.want)
+		}
+	}
+}
+
+// wrappedInfo stands for a logging wrapper reporting its own caller.
+func wrappedInfo(l *Logger, msg string) {
+	l.Info(msg)
+}
+
+func TestWithCallerSkip(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{})
+	skipped := logger.WithCallerSkip(1)
+
+	_, file, line, _ := runtime.Caller(0)
+	direct := fmt.Sprintf(" %s:%d direct", filepath.Base(file), line+8)
+	wrapped := fmt.Sprintf(" %s:%d wrapped", filepath.Base(file), line+12)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			logger.Info("direct")
+		}()
+		go func() {
+			defer wg.Done()
+			wrappedInfo(skipped, "wrapped")
+		}()
+	}
+	wg.Wait()
+	logger.Close()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 100 {
+		t.Fatalf("got %d lines, want 100", len(lines))
+	}
+	for _, l := range lines {
+		if !strings.HasSuffix(l, direct) && !strings.HasSuffix(l, wrapped) {
+			t.Fatalf("line %q reports a wrong caller", l)
+		}
+	}
+}
+
+// alertError is an Error wrapper, the other levels are called directly.
+func alertError(logger *Logger, msg string) {
+	logger.Error(msg)
+}
+
+func TestSetLevelCallDepth(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{})
+	logger.SetLevelCallDepth(LevelError, 1)
+
+	_, file, line, _ := runtime.Caller(0)
+	logger.Info("direct")
+	alertError(logger, "wrapped")
+	logger.Close()
+
+	got := buf.String()
+	for _, want := range []string{
+		fmt.Sprintf(" %s:%d direct\n", filepath.Base(file), line+1),
+		fmt.Sprintf(" %s:%d wrapped\n", filepath.Base(file), line+2),
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+// countingFormatter counts the lines it renders.
+type countingFormatter struct {
+	JSONFormatter
+	n *int32
+}
+
+func (f countingFormatter) Format(lc LogContent) []byte {
+	atomic.AddInt32(f.n, 1)
+	return f.JSONFormatter.Format(lc)
+}
+
+func TestAddSink(t *testing.T) {
+	var text, json1, json2, errs bytes.Buffer
+	var n int32
+	jsonFormatter := countingFormatter{n: &n}
+	logger := NewLogger(&text, Options{})
+	logger.AddSink(&json1, jsonFormatter, LevelDebug)
+	logger.AddSink(&json2, jsonFormatter, LevelDebug)
+	logger.AddSink(&errs, jsonFormatter, LevelError)
+	logger.Info("hello")
+	logger.Error("boom")
+	logger.Close()
+
+	if got := text.String(); !strings.Contains(got, " [info] ") || !strings.Contains(got, " [error] ") {
+		t.Errorf("text = %q", got)
+	}
+	for _, buf := range []*bytes.Buffer{&json1, &json2} {
+		if got := buf.String(); strings.Count(got, `{"time":`) != 2 {
+			t.Errorf("json = %q", got)
+		}
+	}
+	if got := errs.String(); strings.Count(got, `{"time":`) != 1 || !strings.Contains(got, `"msg":"boom"`) {
+		t.Errorf("errors = %q", got)
+	}
+	if n != 2 {
+		t.Errorf("JSON formatted %d times, want once per line", n)
+	}
+}
+
+func TestWithWriter(t *testing.T) {
+	var main, capture bytes.Buffer
+	logger := NewLogger(&main, Options{})
+	batch, restore := logger.WithWriter(&capture)
+	batch.Info("batch step")
+	logger.Info("elsewhere")
+	restore()
+	got := capture.String()
+	batch.Info("after restore")
+	logger.Close()
+
+	if !strings.HasSuffix(got, " batch step\n") || strings.Count(got, "\n") != 1 {
+		t.Errorf("captured %q", got)
+	}
+	if capture.String() != got {
+		t.Errorf("written to after restore: %q", capture.String())
+	}
+	for _, msg := range []string{"batch step", "elsewhere", "after restore"} {
+		if !strings.Contains(main.String(), " "+msg+"\n") {
+			t.Errorf("main output %q misses %q", main.String(), msg)
+		}
+	}
+}
+
+func TestDuplicateWriter(t *testing.T) {
+	var buf, extra bytes.Buffer
+	logger := NewLogger(&buf, Options{})
+	logger.AddWriter(&buf, LevelDebug)
+	logger.AddSink(&extra, JSONFormatter{}, LevelDebug)
+	logger.AddSink(&extra, nil, LevelDebug)
+	logger.Info("once")
+	logger.Close()
+
+	if got := buf.String(); strings.Count(got, " once\n") != 1 || strings.Count(got, "[warn] ") != 1 {
+		t.Errorf("output = %q, want the line once and a single warning", got)
+	}
+	if got := extra.String(); strings.Count(got, "once") != 1 {
+		t.Errorf("sink = %q, want the line once", got)
+	}
+}
+
+func TestDuplicateFile(t *testing.T) {
+	name := filepath.Join(tempDir(t), "out.log")
+	f1, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	f2, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+
+	if !sameWriter(f1, f2) || sameWriter(f1, os.Stdout) {
+		t.Fatal("files are not compared by inode")
+	}
+}
+
+// mapHolder is comparable as a type, not with a map in w.
+type mapHolder struct {
+	w interface{}
+}
+
+func (mapHolder) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestSameWriterNotComparable(t *testing.T) {
+	a := mapHolder{map[string]int{}}
+	if sameWriter(a, a) {
+		t.Error("values holding maps compared equal")
+	}
+	if !sameWriter(mapHolder{1}, mapHolder{1}) {
+		t.Error("equal values compared different")
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	f, err := ioutil.TempFile(tempDir(t), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, w := range []io.Writer{new(bytes.Buffer), f} {
+		logger := NewLogger(w, Options{})
+		if logger.IsTerminal() {
+			t.Errorf("%T is a terminal", w)
+		}
+		logger.Close()
+	}
+}
+
+func TestAddWriter(t *testing.T) {
+	var debugFile, stdout bytes.Buffer
+	logger := NewLogger(&debugFile, Options{Level: LevelDebug})
+	logger.AddWriter(&stdout, LevelInfo)
+	logger.Debug("details")
+	logger.Info("summary")
+	logger.Close()
+
+	if got := debugFile.String(); !strings.Contains(got, "details") || !strings.Contains(got, "summary") {
+		t.Errorf("debug output = %q, want both lines", got)
+	}
+	if got := stdout.String(); strings.Contains(got, "details") || !strings.Contains(got, "summary") {
+		t.Errorf("info output = %q, want only the info line", got)
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	var file, stdout, extra bytes.Buffer
+	logger := NewLogger(&file, Options{Outputs: []io.Writer{&stdout}})
+	logger.AddOutput(&extra)
+	logger.Debug("hello")
+	logger.Close()
+
+	for name, buf := range map[string]*bytes.Buffer{"file": &file, "stdout": &stdout, "extra": &extra} {
+		if got := buf.String(); !strings.HasSuffix(got, " hello\n") {
+			t.Errorf("%s = %q", name, got)
+		}
+	}
+}
+
+func TestLevelOutputs(t *testing.T) {
+	var appLog, errorLog, debugLog bytes.Buffer
+	logger := NewLogger(ioutil.Discard, Options{LevelOutputs: map[LogLevel]io.Writer{
+		LevelInfo:  &appLog,
+		LevelWarn:  &errorLog,
+		LevelError: &errorLog,
+	}})
+	logger.AddLevelWriter(&debugLog, LevelDebug)
+	logger.Debug("d")
+	logger.Info("i")
+	logger.Warn("w")
+	logger.Error("e")
+	logger.Close()
+
+	for name, tt := range map[string]struct {
+		buf  *bytes.Buffer
+		want string
+	}{
+		"app":   {&appLog, "i"},
+		"error": {&errorLog, "we"},
+		"debug": {&debugLog, "d"},
+	} {
+		var got string
+		for _, line := range strings.Split(strings.TrimSuffix(tt.buf.String(), "\n"), "\n") {
+			got += line[strings.LastIndex(line, " ")+1:]
+		}
+		if got != tt.want {
+			t.Errorf("%s got messages %q, want %q", name, got, tt.want)
+		}
+	}
+}
+
+func TestFileFlags(t *testing.T) {
+	_, file, line, _ := runtime.Caller(0)
+	tests := []struct {
+		flag int
+		want string
+	}{
+		{log.Ldate | log.Ltime | log.Lshortfile, fmt.Sprintf(" %s:%d ", filepath.Base(file), line+13)},
+		{log.Ldate | log.Ltime | log.Llongfile, fmt.Sprintf(" %s:%d ", file, line+13)},
+		// no caller without a file flag, as with the log package
+		{log.Ldate | log.Ltime, " [info] hello world"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logger := NewLogger(&buf, Options{Flag: tt.flag})
+		logger.Info("hello world")
+		logger.Close()
+		if got := buf.String(); !strings.Contains(got, tt.want) {
+			t.Errorf("flag %d: got %q, want %q", tt.flag, got, tt.want)
+		}
+	}
+}
+
+func TestCallerResolver(t *testing.T) {
+	var buf bytes.Buffer
+	calls := 0
+	logger := NewLogger(&buf, Options{Flag: log.Llongfile, CallerResolver: func(skip int) (string, int, bool) {
+		calls++
+		_, file, line, ok := runtime.Caller(skip + 1)
+		return "gen/" + filepath.Base(file), line, ok
+	}})
+	_, _, line, _ := runtime.Caller(0)
+	logger.Info("resolved")
+	logger.SetLogLevel(LevelWarn)
+	logger.Info("filtered")
+	logger.Close()
+
+	if want := fmt.Sprintf(" gen/xlog_test.go:%d resolved\n", line+1); !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("got %q, want suffix %q", buf.String(), want)
+	}
+
+	// without file flags, there is no caller to resolve
+	buf.Reset()
+	logger = NewLogger(&buf, Options{Flag: log.Ldate | log.Ltime, CallerResolver: func(int) (string, int, bool) {
+		calls++
+		return "", 0, false
+	}})
+	logger.Info("no caller")
+	logger.Close()
+	if calls != 1 || !strings.Contains(buf.String(), " [info] no caller\n") {
+		t.Errorf("resolver called %d times, got %q", calls, buf.String())
+	}
+}
+
+func TestInternalLines(t *testing.T) {
+	var buf syncBuffer
+	logger := NewLogger(&buf, Options{})
+	_, _, line, _ := runtime.Caller(0)
+	logger.AddOutput(&buf)
+	logger.Close()
+	want := fmt.Sprintf(" xlog_test.go:%d xlog: ignoring a writer added twice, *xlog.syncBuffer\n", line+1)
+	if got := buf.String(); !strings.HasSuffix(got, want) {
+		t.Errorf("got %q, want suffix %q", got, want)
+	}
+
+	stderrFile, err := os.Create(filepath.Join(tempDir(t), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderrFile.Close()
+	stderr := os.Stderr
+	os.Stderr = stderrFile
+	defer func() { os.Stderr = stderr }()
+	logger = NewLogger(ioutil.Discard, Options{Synchronous: true})
+	logger.errorf("%v", errors.New("xlog: failed"))
+	logger.errorf("failed again")
+	logger.Close()
+	b, _ := ioutil.ReadFile(stderrFile.Name())
+	if got, want := string(b), "xlog: failed\nxlog: failed again\n"; got != want {
+		t.Errorf("stderr got %q, want %q", got, want)
+	}
+}
+
+func TestStallWarning(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	out := blockingWriter{release: make(chan struct{})}
+	logger := NewLogger(out, Options{StallWarnAfter: 10 * time.Millisecond})
+	// once the writer took the first line it is stuck in Write
+	logger.Info("stuck")
+	for len(logger.buffer) > 0 {
+		runtime.Gosched()
+	}
+	for logger.TryInfo("filling") == nil {
+	}
+
+	done := make(chan struct{})
+	go func() {
+		logger.Info("blocked")
+		close(done)
+	}()
+
+	warning := make([]byte, 64)
+	n, err := r.Read(warning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(warning[:n]); got != "xlog: writer stalled, buffer full for 10ms\n" {
+		t.Fatalf("warning = %q", got)
+	}
+
+	close(out.release)
+	<-done
+	logger.Close()
+	w.Close()
+}
+
+func TestStaticFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{StaticFields: map[string]string{
+		"pid":  "42",
+		"host": "web 1",
+	}})
+	logger.Debug("a")
+	logger.Info("b")
+	logger.Warn("c")
+	logger.Error("d")
+	logger.Close()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4", len(lines))
+	}
+	for _, line := range lines {
+		if !strings.HasSuffix(line, ` host="web 1" pid=42`) {
+			t.Errorf("line %q lacks the static fields", line)
+		}
+	}
+
+	buf.Reset()
+	logger = NewLogger(&buf, Options{StaticFields: map[string]string{}})
+	logger.Info("b")
+	logger.Close()
+	if got := buf.String(); !strings.HasSuffix(got, " b\n") {
+		t.Errorf("empty StaticFields changed the line: %q", got)
+	}
+}
+
+func TestDerive(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{StaticFields: map[string]string{"app": "api", "env": "dev"}})
+	goroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 5000; i++ {
+		req := logger.Derive(Options{StaticFields: map[string]string{"env": "prod", "req": strconv.Itoa(i)}})
+		if i == 4999 {
+			req.Info("last")
+		}
+	}
+	if n := runtime.NumGoroutine(); n != goroutines {
+		t.Fatalf("Derive started %d goroutines", n-goroutines)
+	}
+	logger.Info("root")
+	logger.Close()
+
+	want := []string{" last app=api env=prod req=4999\n", " root app=api env=dev\n"}
+	for _, w := range want {
+		if !strings.Contains(buf.String(), w) {
+			t.Errorf("got %q, want %q", buf.String(), w)
+		}
+	}
+}
+
+// waitLevel waits for the level of logger to become level.
+func waitLevel(t *testing.T, logger *Logger, level LogLevel) {
+	for i := 0; i < 400; i++ {
+		if LogLevel(atomic.LoadInt32(&logger.level)) == level {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("level is %v, want %v", LogLevel(atomic.LoadInt32(&logger.level)), level)
+}
+
+func TestSetLevelFor(t *testing.T) {
+	logger := NewLogger(ioutil.Discard, Options{Level: LevelWarn})
+	defer logger.Close()
+
+	logger.SetLevelFor(LevelDebug, time.Hour)
+	if !logger.Enabled(LevelDebug) {
+		t.Fatal("Debug is not enabled")
+	}
+	// the latest call wins, the revert goes back to the original level
+	logger.SetLevelFor(LevelInfo, 10*time.Millisecond)
+	if logger.Enabled(LevelDebug) || !logger.Enabled(LevelInfo) {
+		t.Fatal("Info did not replace Debug")
+	}
+	waitLevel(t, logger, LevelWarn)
+
+	// SetLogLevel cancels the revert
+	logger.SetLevelFor(LevelDebug, 10*time.Millisecond)
+	logger.SetLogLevel(LevelError)
+	time.Sleep(50 * time.Millisecond)
+	waitLevel(t, logger, LevelError)
+}
+
+func TestSetOptions(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Flag: log.Ltime})
+	logger.Info("before")
+	logger.Flush()
+
+	logger.SetOptions(Options{Level: LevelWarn, Formatter: JSONFormatter{}})
+	logger.Info("filtered")
+	logger.Warn("after")
+	logger.Close()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %q, want 2 lines", lines)
+	}
+	if !strings.HasSuffix(lines[0], " before") {
+		t.Errorf("got %q, want a text line", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "{") || !strings.Contains(lines[1], `"after"`) || !strings.Contains(lines[1], `"file"`) {
+		t.Errorf("got %q, want a JSON line with the caller", lines[1])
+	}
+}
+
+// TestFatalExitCode runs itself in a subprocess which exits through Fatal or
+// FatalCode.
+func TestFatalExitCode(t *testing.T) {
+	if mode := os.Getenv("XLOG_TEST_FATAL"); mode != "" {
+		logger := NewLogger(os.Stdout, Options{FatalExitCode: 3})
+		logger.Info("before")
+		if mode == "code" {
+			logger.FatalCode(7, "bye")
+		}
+		logger.Fatal("bye")
+		return
+	}
+
+	for mode, want := range map[string]int{"default": 3, "code": 7} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestFatalExitCode$")
+		cmd.Env = append(os.Environ(), "XLOG_TEST_FATAL="+mode)
+		out, err := cmd.Output()
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatalf("%s: got %v, want exit status %d", mode, err, want)
+		}
+		if got := exitErr.ExitCode(); got != want {
+			t.Errorf("%s: exit status %d, want %d", mode, got, want)
+		}
+		if !strings.Contains(string(out), " before\n") || !strings.HasSuffix(string(out), " bye\n") {
+			t.Errorf("%s: got %q, want both lines flushed", mode, out)
+		}
+	}
+}
+
+func TestArgsSnapshot(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{})
+
+	args := []interface{}{"original", 1}
+	logger.Infof("%v %v", args...)
+	// races with the writer goroutine unless it formats a copy
+	args[0], args[1] = "mutated", 2
+	logger.Close()
+
+	if got := buf.String(); !strings.HasSuffix(got, " original 1\n") {
+		t.Fatalf("got %q, want the values at the time of the call", got)
+	}
+}
+
+func TestUnknownLevel(t *testing.T) {
+	var text, logfmt bytes.Buffer
+	logger := NewLogger(&text, Options{})
+	logger.Log(LogLevel(99), "odd")
+	logger.Logf(LevelInfo, "even %d", 2)
+	logger.Close()
+	logger = NewLogger(&logfmt, Options{Formatter: LogfmtFormatter{}})
+	logger.Log(LogLevel(99), "odd")
+	logger.Close()
+
+	if got := text.String(); !strings.Contains(got, " [level=99] ") || !strings.Contains(got, " [info] ") {
+		t.Errorf("text: got %q", got)
+	}
+	if got := logfmt.String(); !strings.Contains(got, " level=99 ") {
+		t.Errorf("logfmt: got %q", got)
+	}
+}
+
+func TestLevelThresholds(t *testing.T) {
+	all := []LogLevel{LevelDebug, LevelInfo, LevelWarn, LevelError, LevelPanic, LevelFatal}
+	tests := []struct {
+		threshold LogLevel
+		want      []LogLevel
+	}{
+		{LevelDebug, all},
+		{LevelInfo, all[1:]},
+		{LevelWarn, all[2:]},
+		{LevelError, all[3:]},
+		// Panic and Fatal never hide Error
+		{LevelPanic, all[3:]},
+		{LevelFatal, all[3:]},
+	}
+	for _, tt := range tests {
+		logger := NewLogger(ioutil.Discard, Options{Level: tt.threshold})
+		var got []LogLevel
+		for _, level := range all {
+			if logger.Enabled(level) {
+				got = append(got, level)
+			}
+		}
+		logger.Close()
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("threshold %v: enabled %v, want %v", tt.threshold, got, tt.want)
+		}
+	}
+}
+
+func TestEnabledLevels(t *testing.T) {
+	logger := NewLogger(ioutil.Discard, Options{Level: LevelWarn})
+	defer logger.Close()
+
+	if got, want := logger.EnabledLevels(), uint8(0x3c); got != want {
+		t.Fatalf("at Warn: %#x, want %#x", got, want)
+	}
+	logger.SetLogLevel(LevelDebug)
+	if got, want := logger.EnabledLevels(), uint8(0x3f); got != want {
+		t.Fatalf("at Debug: %#x, want %#x", got, want)
+	}
+	if NewNopLogger().EnabledLevels() != 0 {
+		t.Fatal("nop logger enables levels")
+	}
+}
+
+func BenchmarkEnabled(b *testing.B) {
+	logger := NewLogger(ioutil.Discard, Options{Level: LevelInfo})
+	defer logger.Close()
+
+	b.Run("Enabled", func(b *testing.B) {
+		n := 0
+		for i := 0; i < b.N; i++ {
+			if logger.Enabled(LevelDebug) {
+				n++
+			}
+		}
+	})
+	b.Run("EnabledLevels", func(b *testing.B) {
+		n := 0
+		enabled := logger.EnabledLevels()
+		for i := 0; i < b.N; i++ {
+			if enabled&(1<<LevelDebug) != 0 {
+				n++
+			}
+		}
+	})
+}
+
+func TestSeverity(t *testing.T) {
+	want := map[LogLevel]int{
+		LevelDebug - 1: 7,
+		LevelDebug:     7,
+		LevelInfo:      6,
+		LevelWarn:      4,
+		LevelError:     3,
+		LevelPanic:     2,
+		LevelFatal:     1,
+		LevelFatal + 1: 1,
+	}
+	for level, severity := range want {
+		if got := level.Severity(); got != severity {
+			t.Errorf("LogLevel(%d).Severity() = %d, want %d", level, got, severity)
+		}
+	}
+}
+
+func TestPanicFlushesWriteAhead(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{WriteAhead: true})
+	defer logger.Close()
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("recovered %v, want boom", r)
+			}
+		}()
+		logger.Info("buffered")
+		logger.Panic("boom")
+	}()
+
+	if got := buf.String(); !strings.Contains(got, " buffered\n") || !strings.Contains(got, " boom\n") {
+		t.Fatalf("output after Panic = %q, want both lines", got)
+	}
+}
+
+func benchmarkFile(b *testing.B, opts Options) {
+	dir, err := ioutil.TempDir("", "xlog")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger, err := NewLoggerFromFile(filepath.Join(dir, "bench.log"), opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Infof("request %d done", i)
+	}
+	logger.Close()
+}
+
+func BenchmarkFile(b *testing.B) {
+	benchmarkFile(b, Options{})
+}
+
+func BenchmarkFileWriteAhead(b *testing.B) {
+	benchmarkFile(b, Options{WriteAhead: true})
+}
+
+func TestMonotonicOrder(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{MonotonicOrder: true})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				logger.Info("tick")
+			}
+		}()
+	}
+	wg.Wait()
+	logger.Close()
+
+	var last time.Time
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		ts, err := time.ParseInLocation("2006/01/02 15:04:05.000000", line[:len("2006/01/02 15:04:05.000000")], time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ts.Before(last) {
+			t.Fatalf("time went backwards: %v after %v", ts, last)
+		}
+		last = ts
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to read while a logger writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCloseTimeout(t *testing.T) {
+	w := blockingWriter{release: make(chan struct{})}
+	defer close(w.release)
+	logger := NewLogger(w, Options{CloseTimeout: 50 * time.Millisecond})
+	logger.Info("stuck")
+
+	start := time.Now()
+	if err := logger.Flush(); err != context.DeadlineExceeded {
+		t.Fatalf("Flush = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := logger.Close(); err != context.DeadlineExceeded {
+		t.Fatalf("Close = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Flush and Close took %v", d)
+	}
+	if err := logger.TryInfo("late"); err != ErrClosed {
+		t.Fatalf("TryInfo after Close = %v, want %v", err, ErrClosed)
+	}
+}
+
+// stuckWriter blocks until release is closed, telling entered first.
+type stuckWriter struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (w stuckWriter) Write(p []byte) (int, error) {
+	w.entered <- struct{}{}
+	<-w.release
+	return len(p), nil
+}
+
+func TestCloseTimeoutSynchronous(t *testing.T) {
+	w := stuckWriter{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(w.release)
+	logger := NewLogger(w, Options{Synchronous: true, CloseTimeout: 50 * time.Millisecond})
+	go logger.Info("stuck")
+	<-w.entered
+
+	start := time.Now()
+	if err := logger.Flush(); err != context.DeadlineExceeded {
+		t.Fatalf("Flush = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := logger.Close(); err != context.DeadlineExceeded {
+		t.Fatalf("Close = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Flush and Close took %v", d)
+	}
+	if err := logger.TryInfo("late"); err != ErrClosed {
+		t.Fatalf("TryInfo after Close = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestLoggerWithContext(t *testing.T) {
+	// other tests leave loggers running
+	ignore := goleak.IgnoreCurrent()
+
+	bufs := make([]*syncBuffer, 100)
+	for i := range bufs {
+		bufs[i] = new(syncBuffer)
+		ctx, cancel := context.WithCancel(context.Background())
+		logger := NewLoggerWithContext(ctx, bufs[i], Options{WriteAhead: i%2 == 0})
+		logger.Info("hello")
+		cancel()
+	}
+
+	// the loggers are closed once their goroutines are gone
+	goleak.VerifyNone(t, ignore)
+	for i, buf := range bufs {
+		if got := buf.String(); !strings.HasSuffix(got, " hello\n") {
+			t.Fatalf("logger %d: got %q", i, got)
+		}
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var buf syncBuffer
+	logger := NewLogger(&buf, Options{WriteAhead: true})
+	defer logger.Close()
+
+	logger.Info("hello")
+	if err := logger.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.HasSuffix(got, " hello\n") {
+		t.Fatalf("got %q after Flush", got)
+	}
+	if err := logger.Sync(); err != nil {
+		t.Fatalf("Sync to a buffer: %v", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	logFile := filepath.Join(tempDir(t), "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{WriteAhead: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hello")
+	if err := logger.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), " hello\n") {
+		t.Fatalf("got %q after Sync", b)
+	}
+	logger.Info("bye")
+	if err := logger.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(logFile); !strings.HasSuffix(string(b), " bye\n") {
+		t.Fatalf("got %q after Close", b)
+	}
+	if err := logger.Sync(); err != nil {
+		t.Fatalf("Sync after Close: %v", err)
+	}
+}
+
+func TestSynchronous(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Options{Synchronous: true})
+	if n := runtime.NumGoroutine(); n != goroutines {
+		t.Fatalf("NewLogger started %d goroutines", n-goroutines)
+	}
+
+	for i := 0; i < 100; i++ {
+		logger.Infof("line %d", i)
+		// written before the call returns, no Close or Flush needed
+		if want := fmt.Sprintf(" line %d\n", i); !strings.HasSuffix(buf.String(), want) {
+			t.Fatalf("after line %d: got %q", i, buf.String())
+		}
+	}
+	if err := logger.TryInfo("try"); err != nil || !strings.HasSuffix(buf.String(), " try\n") {
+		t.Fatalf("TryInfo = %v, got %q", err, buf.String())
+	}
+	logger.Close()
+}
+
+func TestSynchronousRotation(t *testing.T) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 15, 59, 0, 0, time.Local)}
+	logFile := filepath.Join(tempDir(t), "test.log")
+	logger, err := NewLoggerFromFile(logFile, Options{RotateInterval: Hourly, Synchronous: true, Now: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	logger.Info("at 15")
+	clock.Set(time.Date(2024, 1, 1, 16, 0, 0, 0, time.Local))
+	logger.Info("at 16")
+	for name, want := range map[string]string{
+		logFile + ".2024010115": " at 15",
+		logFile + ".2024010116": " at 16",
+	} {
+		if got := readLines(t, name); len(got) != 1 || !strings.HasSuffix(got[0], want) {
+			t.Errorf("%s has %q", filepath.Base(name), got)
+		}
+	}
 }
@@ -0,0 +1,253 @@
+// Package xlogkafka publishes the lines of an xlog.Logger to a Kafka topic,
+// batched in the background. It does not depend on a Kafka client: the
+// application passes a Producer, e.g. for github.com/segmentio/kafka-go:
+//
+//	type producer struct{ w *kafka.Writer }
+//
+//	func (p producer) Produce(ctx context.Context, msgs []xlogkafka.Message) error {
+//		kmsgs := make([]kafka.Message, len(msgs))
+//		for i, m := range msgs {
+//			kmsgs[i] = kafka.Message{Key: m.Key, Value: m.Value, Time: m.Time}
+//		}
+//		return p.w.WriteMessages(ctx, kmsgs...)
+//	}
+//
+//	s := xlogkafka.New(producer{&kafka.Writer{Addr: kafka.TCP("broker:9092"), Topic: "logs"}}, xlogkafka.Options{})
+//	logger.AddSink(s, s, xlog.LevelInfo)
+//	defer s.Close()
+//
+// The key of a message is the level of the line, e.g. "info", or the value
+// of Options.KeyField, so that the lines of a key stay in order in their
+// partition.
+package xlogkafka
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gnenux/xlog"
+)
+
+// Message is a line to publish.
+type Message struct {
+	Key   []byte
+	Value []byte
+	Time  time.Time
+}
+
+// Producer publishes a batch of messages, e.g. with a Kafka client.
+type Producer interface {
+	Produce(ctx context.Context, msgs []Message) error
+}
+
+// Options configures a Sink.
+type Options struct {
+	// KeyField names the field whose value keys the messages, the lines
+	// without it are keyed by level. The level keys all lines when empty.
+	KeyField string
+	// Formatter renders the value of the messages, xlog.JSONFormatter by
+	// default.
+	Formatter xlog.Formatter
+	// BatchSize is the number of messages which triggers a Produce call,
+	// 100 by default.
+	BatchSize int
+	// BatchTimeout is how long the first message of a batch waits for the
+	// batch to fill, a second by default.
+	BatchTimeout time.Duration
+	// ProduceTimeout bounds a Produce call, 10 seconds by default.
+	ProduceTimeout time.Duration
+	// QueueSize is the number of messages waiting for a batch, 1024 by
+	// default, the oldest is dropped when it is full.
+	QueueSize int
+	// OnError, when set, is called with the messages of a failed Produce
+	// call, from the goroutine of the sink.
+	OnError func(err error, msgs []Message)
+}
+
+// ErrClosed is returned by Write once the sink is closed.
+var ErrClosed = errors.New("xlogkafka: sink is closed")
+
+// Sink is both the formatter and the writer of an xlog sink, see the
+// package example. The formatter packs the key and the value of a message
+// for the writer, so Sink only works paired with itself.
+type Sink struct {
+	p    Producer
+	opts Options
+
+	dropped uint64
+	closed  int32
+	queue   chan Message
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// New returns a Sink publishing with p.
+func New(p Producer, opts Options) *Sink {
+	if opts.Formatter == nil {
+		opts.Formatter = xlog.JSONFormatter{}
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BatchTimeout <= 0 {
+		opts.BatchTimeout = time.Second
+	}
+	if opts.ProduceTimeout <= 0 {
+		opts.ProduceTimeout = 10 * time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	s := &Sink{
+		p:     p,
+		opts:  opts,
+		queue: make(chan Message, opts.QueueSize),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Format renders lc for Write: the length of the key as a uvarint, the key,
+// the time of the line in nanoseconds as 8 bytes and the value rendered by
+// Options.Formatter.
+func (s *Sink) Format(lc xlog.LogContent) []byte {
+	key := s.key(&lc)
+	value := s.opts.Formatter.Format(lc)
+	b := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(key)+8+len(value))
+	b = b[:binary.PutUvarint(b, uint64(len(key)))]
+	b = append(b, key...)
+	var ns [8]byte
+	binary.BigEndian.PutUint64(ns[:], uint64(lc.Time.UnixNano()))
+	b = append(b, ns[:]...)
+	return append(b, value...)
+}
+
+// key returns the key of the message of lc. A lazy key field is replaced in
+// lc by its value, so that the formatter does not compute it again.
+func (s *Sink) key(lc *xlog.LogContent) string {
+	if s.opts.KeyField != "" {
+		for i, f := range lc.Fields {
+			if f.Key != s.opts.KeyField {
+				continue
+			}
+			v := f.Interface()
+			if _, lazy := f.Value.(func() interface{}); lazy {
+				// lc.Fields may be shared with the logger
+				fields := append([]xlog.Field(nil), lc.Fields...)
+				fields[i] = xlog.Any(f.Key, v)
+				lc.Fields = fields
+			}
+			return fmt.Sprint(v)
+		}
+	}
+	return lc.Level.String()
+}
+
+// Write queues the message packed by Format in p, it fails only once s is
+// closed or when p was not rendered by Format.
+func (s *Sink) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return 0, ErrClosed
+	}
+	n, size := binary.Uvarint(p)
+	// n is compared before adding to it, a huge n would wrap around
+	if size <= 0 || n > uint64(len(p)-size) || uint64(len(p)-size)-n < 8 {
+		return 0, errors.New("xlogkafka: line not rendered by Sink.Format")
+	}
+	key := p[size : size+int(n)]
+	rest := p[size+int(n):]
+	msg := Message{
+		Key:   append([]byte(nil), key...),
+		Value: append([]byte(nil), rest[8:]...),
+		Time:  time.Unix(0, int64(binary.BigEndian.Uint64(rest))),
+	}
+	for {
+		select {
+		case s.queue <- msg:
+			return len(p), nil
+		default:
+		}
+		select {
+		case <-s.queue:
+			atomic.AddUint64(&s.dropped, 1)
+		default:
+		}
+	}
+}
+
+// Dropped returns the number of messages dropped on a full queue or in a
+// failed batch.
+func (s *Sink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close publishes the messages still queued and stops s. Close the logger
+// first, so that its last lines are written to s.
+func (s *Sink) Close() error {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return ErrClosed
+	}
+	close(s.quit)
+	<-s.done
+	return nil
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+
+	var batch []Message
+	var timer *time.Timer
+	var expired <-chan time.Time
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		if len(batch) > 0 {
+			s.produce(batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case msg := <-s.queue:
+			batch = append(batch, msg)
+			if len(batch) == 1 {
+				timer = time.NewTimer(s.opts.BatchTimeout)
+				expired = timer.C
+			}
+			if len(batch) >= s.opts.BatchSize {
+				flush()
+			}
+		case <-expired:
+			flush()
+		case <-s.quit:
+			for len(s.queue) > 0 {
+				batch = append(batch, <-s.queue)
+				if len(batch) >= s.opts.BatchSize {
+					flush()
+				}
+			}
+			flush()
+			return
+		}
+	}
+}
+
+func (s *Sink) produce(batch []Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.ProduceTimeout)
+	defer cancel()
+	if err := s.p.Produce(ctx, batch); err != nil {
+		atomic.AddUint64(&s.dropped, uint64(len(batch)))
+		if s.opts.OnError != nil {
+			s.opts.OnError(err, batch)
+		}
+	}
+}
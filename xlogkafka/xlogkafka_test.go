@@ -0,0 +1,118 @@
+package xlogkafka
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gnenux/xlog"
+)
+
+type fakeProducer struct {
+	mu      sync.Mutex
+	batches [][]Message
+	err     error
+}
+
+func (p *fakeProducer) Produce(ctx context.Context, msgs []Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batches = append(p.batches, msgs)
+	return p.err
+}
+
+func TestSink(t *testing.T) {
+	p := new(fakeProducer)
+	s := New(p, Options{KeyField: "user", BatchSize: 2})
+	logger := xlog.NewLogger(ioutil.Discard, xlog.Options{})
+	logger.AddSink(s, s, xlog.LevelDebug)
+	logger.InfoFields("login", xlog.String("user", "alice"))
+	logger.Warn("disk almost full")
+	logger.DebugFields("query", xlog.Int("user", 42))
+	logger.Close()
+	s.Close()
+
+	if len(p.batches) != 2 || len(p.batches[0]) != 2 || len(p.batches[1]) != 1 {
+		t.Fatalf("got batches %v", p.batches)
+	}
+	for i, want := range []struct{ key, msg string }{
+		{"alice", `"msg":"login"`},
+		{"warn", `"msg":"disk almost full"`},
+		{"42", `"msg":"query"`},
+	} {
+		m := p.batches[i/2][i%2]
+		if string(m.Key) != want.key || !strings.Contains(string(m.Value), want.msg) {
+			t.Errorf("message %d = %q: %q, want %q: ...%s...", i, m.Key, m.Value, want.key, want.msg)
+		}
+	}
+}
+
+func TestSinkTimeAndLazyKey(t *testing.T) {
+	p := new(fakeProducer)
+	s := New(p, Options{KeyField: "user"})
+	now := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	logger := xlog.NewLogger(ioutil.Discard, xlog.Options{Now: func() time.Time { return now }})
+	logger.AddSink(s, s, xlog.LevelDebug)
+	calls := 0
+	logger.Infow("login", "user", func() interface{} {
+		calls++
+		return "alice"
+	})
+	logger.Close()
+	s.Close()
+
+	if len(p.batches) != 1 || len(p.batches[0]) != 1 {
+		t.Fatalf("got batches %v", p.batches)
+	}
+	m := p.batches[0][0]
+	if !m.Time.Equal(now) {
+		t.Errorf("time = %v, want the time of the line %v", m.Time, now)
+	}
+	if string(m.Key) != "alice" || !strings.Contains(string(m.Value), `"user":"alice"`) {
+		t.Errorf("got %q: %q", m.Key, m.Value)
+	}
+	// once for the main output of the logger, once for the sink
+	if calls != 2 {
+		t.Errorf("lazy key computed %d times, want twice", calls)
+	}
+}
+
+func TestSinkOnError(t *testing.T) {
+	p := &fakeProducer{err: errors.New("broker down")}
+	var failed []Message
+	s := New(p, Options{OnError: func(err error, msgs []Message) {
+		failed = append(failed, msgs...)
+	}})
+	logger := xlog.NewLogger(ioutil.Discard, xlog.Options{})
+	logger.AddSink(s, s, xlog.LevelDebug)
+	logger.Error("boom")
+	logger.Close()
+	s.Close()
+
+	if len(failed) != 1 || string(failed[0].Key) != "error" || s.Dropped() != 1 {
+		t.Fatalf("got failed %v, dropped %d", failed, s.Dropped())
+	}
+	if _, err := s.Write([]byte("x")); err != ErrClosed {
+		t.Errorf("Write after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestSinkMalformed(t *testing.T) {
+	s := New(new(fakeProducer), Options{})
+	defer s.Close()
+	for _, p := range [][]byte{
+		nil,
+		{0x80},
+		{3, 'k', 'e', 'y'},
+		// a key length of 2^64-8, which n+8 wraps around to 0
+		{0xf8, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 'x'},
+	} {
+		if _, err := s.Write(p); err == nil {
+			t.Errorf("Write(% x) = nil, want an error", p)
+		}
+	}
+}
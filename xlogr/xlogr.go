@@ -0,0 +1,92 @@
+// Package xlogr implements a logr.LogSink writing to an xlog.Logger, for
+// the Kubernetes ecosystem, e.g.
+//
+//	ctrl.SetLogger(xlogr.New(logger))
+//
+// V(0) logs at Info and higher verbosities at Debug, Error logs at Error
+// with the error under the "error" key. The names of WithName are joined by
+// "/" under the "logger" key.
+//
+// It is a module of its own, so that only its users depend on logr.
+package xlogr
+
+import (
+	"github.com/gnenux/xlog"
+	"github.com/go-logr/logr"
+)
+
+// New returns a logr.Logger writing to l.
+func New(l *xlog.Logger) logr.Logger {
+	return logr.New(&sink{l: l})
+}
+
+type sink struct {
+	l      *xlog.Logger
+	name   string
+	values []interface{}
+}
+
+var _ logr.CallDepthLogSink = (*sink)(nil)
+
+func (s *sink) Init(info logr.RuntimeInfo) {
+	// the frames of logr and of the sink
+	s.l = s.l.WithCallerSkip(info.CallDepth + 1)
+}
+
+func level(v int) xlog.LogLevel {
+	if v > 0 {
+		return xlog.LevelDebug
+	}
+	return xlog.LevelInfo
+}
+
+func (s *sink) Enabled(v int) bool {
+	return s.l.Enabled(level(v))
+}
+
+func (s *sink) Info(v int, msg string, keysAndValues ...interface{}) {
+	if level(v) == xlog.LevelDebug {
+		s.l.Debugw(msg, s.keysAndValues(keysAndValues)...)
+	} else {
+		s.l.Infow(msg, s.keysAndValues(keysAndValues)...)
+	}
+}
+
+func (s *sink) Error(err error, msg string, keysAndValues ...interface{}) {
+	kv := append([]interface{}{"error", err}, keysAndValues...)
+	s.l.Errorw(msg, s.keysAndValues(kv)...)
+}
+
+// keysAndValues returns those of a call after the name and the values of s.
+func (s *sink) keysAndValues(kv []interface{}) []interface{} {
+	if s.name == "" && len(s.values) == 0 {
+		return kv
+	}
+	all := make([]interface{}, 0, 2+len(s.values)+len(kv))
+	if s.name != "" {
+		all = append(all, "logger", s.name)
+	}
+	return append(append(all, s.values...), kv...)
+}
+
+func (s *sink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	ns := *s
+	ns.values = append(s.values[:len(s.values):len(s.values)], keysAndValues...)
+	return &ns
+}
+
+func (s *sink) WithName(name string) logr.LogSink {
+	ns := *s
+	if s.name != "" {
+		ns.name = s.name + "/" + name
+	} else {
+		ns.name = name
+	}
+	return &ns
+}
+
+func (s *sink) WithCallDepth(depth int) logr.LogSink {
+	ns := *s
+	ns.l = s.l.WithCallerSkip(depth)
+	return &ns
+}
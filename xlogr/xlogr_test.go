@@ -0,0 +1,52 @@
+package xlogr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/gnenux/xlog"
+)
+
+func TestLogSink(t *testing.T) {
+	var buf bytes.Buffer
+	logger := xlog.NewLogger(&buf, xlog.Options{Level: xlog.LevelInfo, Flag: log.Lshortfile})
+	lr := New(logger).WithName("ctrl").WithName("pods").WithValues("ns", "default")
+
+	_, _, line, _ := runtime.Caller(0)
+	lr.Info("reconciled", "pod", "web-1")
+	lr.V(1).Info("filtered")
+	lr.Error(errors.New("boom"), "failed", "pod", "web-2")
+	logger.Close()
+
+	want := []string{
+		fmt.Sprintf("[info] xlogr_test.go:%d reconciled logger=ctrl/pods ns=default pod=web-1", line+1),
+		fmt.Sprintf("[error] xlogr_test.go:%d failed logger=ctrl/pods ns=default error=boom pod=web-2", line+3),
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %q, want %q", lines, want)
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], want[i]) {
+			t.Errorf("got %q, want suffix %q", lines[i], want[i])
+		}
+	}
+}
+
+func TestVerbosity(t *testing.T) {
+	logger := xlog.NewLogger(&bytes.Buffer{}, xlog.Options{Level: xlog.LevelDebug})
+	defer logger.Close()
+	lr := New(logger)
+	if !lr.V(0).Enabled() || !lr.V(4).Enabled() {
+		t.Error("V levels disabled at Debug")
+	}
+	logger.SetLogLevel(xlog.LevelInfo)
+	if !lr.V(0).Enabled() || lr.V(1).Enabled() {
+		t.Error("V(1) enabled at Info")
+	}
+}
@@ -0,0 +1,8 @@
+module github.com/gnenux/xlog/xloki
+
+go 1.14
+
+require (
+	github.com/gnenux/xlog v0.0.0-20261016103129-6de108a51b87
+	github.com/golang/snappy v0.0.4
+)
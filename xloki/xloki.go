@@ -0,0 +1,192 @@
+// Package xloki pushes the lines of an xlog.Logger to the
+// /loki/api/v1/push endpoint of Grafana Loki, in batches encoded in
+// protobuf and compressed with snappy. A Sink is both the formatter and the
+// writer of a sink:
+//
+//	s := xloki.New("http://loki:3100/loki/api/v1/push", xloki.Options{
+//		Labels:       []string{"app"},
+//		StaticLabels: map[string]string{"job": "api"},
+//	})
+//	logger.AddSink(s, s, xlog.LevelInfo)
+//	defer s.Close()
+//
+// It is a module of its own, so that only its users depend on snappy.
+package xloki
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gnenux/xlog"
+	"github.com/golang/snappy"
+)
+
+// Options configures a Sink.
+type Options struct {
+	// Labels names the fields and static fields which become labels of
+	// the stream of a line, e.g. "app" and "host", next to "level".
+	// Keep them few and of few values, each set of labels is a stream.
+	Labels []string
+	// StaticLabels are added to every stream, e.g. {"job": "api"}.
+	StaticLabels map[string]string
+	// Formatter renders the lines, xlog.LogfmtFormatter by default.
+	Formatter xlog.Formatter
+	// HTTP configures the batching, retries, and headers of the requests,
+	// e.g. X-Scope-OrgID or Authorization. Its JSONArray, Encode and
+	// ContentType are ignored.
+	HTTP xlog.HTTPOptions
+}
+
+// Sink pushes lines to Loki. The lines are queued and sent by a goroutine
+// like those of xlog.HTTPWriter, Close the logger first so that its last
+// lines make it to the sink.
+type Sink struct {
+	*xlog.HTTPWriter
+	opts Options
+}
+
+// New returns a Sink pushing to url.
+func New(url string, opts Options) *Sink {
+	if opts.Formatter == nil {
+		opts.Formatter = xlog.LogfmtFormatter{}
+	}
+	opts.HTTP.Encode = encodePush
+	opts.HTTP.ContentType = "application/x-protobuf"
+	return &Sink{HTTPWriter: xlog.NewHTTPWriter(url, opts.HTTP), opts: opts}
+}
+
+// Format renders lc for Write: the labels of its stream, e.g.
+// {app="api", level="info"}, with their length as a uvarint, the time in
+// nanoseconds as 8 bytes and the line.
+func (s *Sink) Format(lc xlog.LogContent) []byte {
+	labels := s.labels(lc)
+	line := strings.TrimSuffix(string(s.opts.Formatter.Format(lc)), "\n")
+
+	b := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(labels)+8+len(line))
+	b = b[:binary.PutUvarint(b, uint64(len(labels)))]
+	b = append(b, labels...)
+	var ns [8]byte
+	binary.BigEndian.PutUint64(ns[:], uint64(lc.Time.UnixNano()))
+	b = append(b, ns[:]...)
+	return append(b, line...)
+}
+
+// labels returns the label set of the stream of lc, sorted by name. The
+// names are sanitized before they are set, so of two names sanitized
+// alike, e.g. "app.name" and "app_name", the later one wins.
+func (s *Sink) labels(lc xlog.LogContent) string {
+	labels := map[string]string{"level": lc.Level.String()}
+	for k, v := range s.opts.StaticLabels {
+		labels[labelName(k)] = v
+	}
+	static := lc.StaticFields()
+	for _, name := range s.opts.Labels {
+		for _, f := range lc.Fields {
+			if f.Key == name {
+				labels[labelName(name)] = fmt.Sprint(f.Interface())
+			}
+		}
+		for _, f := range static {
+			if f.Key == name {
+				labels[labelName(name)] = fmt.Sprint(f.Interface())
+			}
+		}
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[name]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// labelName replaces the characters Loki does not allow in label names by
+// '_'.
+func labelName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	b := []byte(name)
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// encodePush encodes the lines rendered by Format as a PushRequest of the
+// Loki protobuf API, a stream per label set in the order they first
+// appear, compressed with snappy:
+//
+//	PushRequest { repeated Stream streams = 1; }
+//	Stream { string labels = 1; repeated Entry entries = 2; }
+//	Entry { Timestamp timestamp = 1; string line = 2; }
+//	Timestamp { int64 seconds = 1; int32 nanos = 2; }
+func encodePush(batch [][]byte) []byte {
+	var order []string
+	entries := make(map[string][]byte)
+	for _, p := range batch {
+		n, size := binary.Uvarint(p)
+		// n is compared before adding to it, a huge n would wrap around
+		if size <= 0 || n > uint64(len(p)-size) || uint64(len(p)-size)-n < 8 {
+			// not rendered by Sink.Format
+			continue
+		}
+		labels := string(p[size : size+int(n)])
+		p = p[size+int(n):]
+		ns := int64(binary.BigEndian.Uint64(p))
+		line := p[8:]
+
+		var ts []byte
+		ts = appendProtoVarint(ts, 1, uint64(ns/1e9))
+		ts = appendProtoVarint(ts, 2, uint64(ns%1e9))
+		var entry []byte
+		entry = appendProtoBytes(entry, 1, ts)
+		entry = appendProtoBytes(entry, 2, line)
+
+		if _, ok := entries[labels]; !ok {
+			order = append(order, labels)
+		}
+		entries[labels] = appendProtoBytes(entries[labels], 2, entry)
+	}
+
+	var req []byte
+	for _, labels := range order {
+		stream := appendProtoBytes(nil, 1, []byte(labels))
+		stream = append(stream, entries[labels]...)
+		req = appendProtoBytes(req, 1, stream)
+	}
+	return snappy.Encode(nil, req)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3)
+	return appendUvarint(b, v)
+}
+
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	b = appendUvarint(b, uint64(field)<<3|2)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
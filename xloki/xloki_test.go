@@ -0,0 +1,121 @@
+package xloki
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gnenux/xlog"
+	"github.com/golang/snappy"
+)
+
+// protoFields splits a protobuf message into its length-delimited fields,
+// by number, skipping the varints.
+func protoFields(t *testing.T, b []byte) map[int][][]byte {
+	fields := make(map[int][][]byte)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		if key&7 == 0 {
+			_, n = binary.Uvarint(b)
+			b = b[n:]
+			continue
+		}
+		size, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < size {
+			t.Fatalf("bad protobuf % x", b)
+		}
+		fields[int(key>>3)] = append(fields[int(key>>3)], b[n:n+int(size)])
+		b = b[n+int(size):]
+	}
+	return fields
+}
+
+func TestSink(t *testing.T) {
+	var body []byte
+	var contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, contentType = b, r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := New(srv.URL, Options{
+		Labels:       []string{"app"},
+		StaticLabels: map[string]string{"job": "api"},
+	})
+	logger := xlog.NewLogger(ioutil.Discard, xlog.Options{StaticFields: map[string]string{"app": "shop"}})
+	logger.AddSink(s, s, xlog.LevelDebug)
+	logger.Info("one")
+	logger.Warn("two")
+	logger.Info("three")
+	logger.Close()
+	s.Close()
+
+	if contentType != "application/x-protobuf" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+	req, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := protoFields(t, req)[1]
+	if len(streams) != 2 {
+		t.Fatalf("got %d streams, want 2", len(streams))
+	}
+	for i, want := range []struct {
+		labels string
+		lines  []string
+	}{
+		{`{app="shop", job="api", level="info"}`, []string{"one", "three"}},
+		{`{app="shop", job="api", level="warn"}`, []string{"two"}},
+	} {
+		stream := protoFields(t, streams[i])
+		if got := string(stream[1][0]); got != want.labels {
+			t.Errorf("stream %d labels = %s, want %s", i, got, want.labels)
+		}
+		if len(stream[2]) != len(want.lines) {
+			t.Fatalf("stream %d has %d entries, want %d", i, len(stream[2]), len(want.lines))
+		}
+		for j, entry := range stream[2] {
+			e := protoFields(t, entry)
+			if len(e[1]) != 1 || !strings.HasSuffix(string(e[2][0]), "msg="+want.lines[j]+" app=shop") {
+				t.Errorf("stream %d entry %d = %q", i, j, e[2])
+			}
+		}
+	}
+}
+
+func TestLabelNames(t *testing.T) {
+	s := &Sink{opts: Options{
+		Labels:       []string{"app.name", "9lives", ""},
+		StaticLabels: map[string]string{"app_name": "static", "k-8": "v"},
+	}}
+	lc := xlog.LogContent{
+		Level:  xlog.LevelInfo,
+		Fields: []xlog.Field{xlog.String("app.name", "shop"), xlog.Int("9lives", 9), xlog.String("", "empty")},
+	}
+	// the fields override the static labels sanitized alike
+	want := `{_="empty", _lives="9", app_name="shop", k_8="v", level="info"}`
+	if got := s.labels(lc); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestEncodePushMalformed(t *testing.T) {
+	batch := [][]byte{
+		nil,
+		{0x80},
+		{3, 'a', 'b', 'c'},
+		// a labels length of 2^64-8, which n+8 wraps around to 0
+		{0xf8, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 'x'},
+	}
+	req, err := snappy.Decode(nil, encodePush(batch))
+	if err != nil || len(req) != 0 {
+		t.Errorf("got % x, %v, want an empty request", req, err)
+	}
+}